@@ -0,0 +1,397 @@
+// Package avi reads motion jpeg from AVI files, the container most NVRs export recordings in.
+package avi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+	"iter"
+	"net/textproto"
+	"strings"
+	"time"
+
+	"github.com/WarehouseRobotics/go-mjpeg"
+)
+
+var (
+	// ErrNotAVI is returned when the file is not a RIFF AVI file.
+	ErrNotAVI = errors.New("avi: not an AVI file")
+	// ErrNotMJPEG is returned when the file has no motion jpeg video stream.
+	ErrNotMJPEG = errors.New("avi: no motion jpeg video stream")
+	// ErrCorrupt is returned when a chunk does not fit in its list.
+	ErrCorrupt = errors.New("avi: chunk exceeds its list")
+)
+
+// maxHeaderChunk is the size above which a header chunk is not read.
+const maxHeaderChunk = 1 << 20
+
+// Option configures a Reader.
+type Option func(*Reader)
+
+// WithMaxFrameSize limit the size of a frame to n bytes, like
+// mjpeg.WithMaxFrameSize. A larger frame is skipped without being buffered,
+// Next returns *mjpeg.FrameSizeError for it.
+func WithMaxFrameSize(n int) Option {
+	return func(r *Reader) {
+		r.maxFrameSize = n
+	}
+}
+
+// mjpegFourCCs are the codec identifiers used for motion jpeg video.
+var mjpegFourCCs = map[string]bool{
+	"MJPG": true,
+	"AVRN": true,
+	"DMB1": true,
+	"JPEG": true,
+	"AVI1": true,
+}
+
+// Reader reads frames of the motion jpeg video stream of an AVI file
+type Reader struct {
+	r io.Reader
+
+	width, height int
+	frameDuration time.Duration
+	totalFrames   int
+	stream        string
+
+	// ends holds the end offsets of the chunks the reader is inside of.
+	ends []int64
+	off  int64
+	seq  uint64
+
+	maxFrameSize int
+}
+
+// NewReader return new instance of Reader. It reads the AVI headers up to the
+// start of the movie data.
+func NewReader(r io.Reader, opts ...Option) (*Reader, error) {
+	ar := &Reader{r: r}
+	for _, opt := range opts {
+		opt(ar)
+	}
+	var hdr [12]byte
+	if err := ar.read(hdr[:]); err != nil {
+		return nil, err
+	}
+	if string(hdr[0:4]) != "RIFF" || string(hdr[8:12]) != "AVI " {
+		return nil, ErrNotAVI
+	}
+	ar.ends = append(ar.ends, 8+int64(binary.LittleEndian.Uint32(hdr[4:8])))
+
+	for {
+		id, size, err := ar.chunk()
+		if err == errEndOfList {
+			return nil, ErrNotMJPEG
+		}
+		if err != nil {
+			return nil, err
+		}
+		if id != "LIST" {
+			if err := ar.skip(size); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		var typ [4]byte
+		if err := ar.read(typ[:]); err != nil {
+			return nil, err
+		}
+		switch string(typ[:]) {
+		case "hdrl":
+			// The header list is read chunk by chunk below.
+			ar.ends = append(ar.ends, ar.off+size-4)
+			if err := ar.readHeaders(); err != nil {
+				return nil, err
+			}
+		case "movi":
+			if ar.stream == "" {
+				return nil, ErrNotMJPEG
+			}
+			ar.ends = append(ar.ends, ar.off+size-4)
+			return ar, nil
+		default:
+			if err := ar.skip(size - 4); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+// Width return the width of the video.
+func (r *Reader) Width() int { return r.width }
+
+// Height return the height of the video.
+func (r *Reader) Height() int { return r.height }
+
+// FrameDuration return the duration of a frame.
+func (r *Reader) FrameDuration() time.Duration { return r.frameDuration }
+
+// FrameCount return the number of frames declared in the main header. It is
+// zero or wrong in files which were not closed properly.
+func (r *Reader) FrameCount() int { return r.totalFrames }
+
+func (r *Reader) read(b []byte) error {
+	n, err := io.ReadFull(r.r, b)
+	r.off += int64(n)
+	if err == io.ErrUnexpectedEOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// skip discards a chunk of n bytes and its padding.
+func (r *Reader) skip(n int64) error {
+	if n%2 == 1 {
+		n++
+	}
+	return r.discard(n)
+}
+
+func (r *Reader) discard(n int64) error {
+	c, err := io.CopyN(io.Discard, r.r, n)
+	r.off += c
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+var errEndOfList = errors.New("avi: end of list")
+
+// chunk reads the next chunk header inside the current list. At the end of
+// the list it leaves the list and returns errEndOfList, a chunk larger than
+// the rest of the list is ErrCorrupt.
+func (r *Reader) chunk() (string, int64, error) {
+	if len(r.ends) == 0 {
+		return "", 0, errEndOfList
+	}
+	if end := r.ends[len(r.ends)-1]; r.off+8 > end {
+		r.ends = r.ends[:len(r.ends)-1]
+		if end > r.off {
+			if err := r.discard(end - r.off); err != nil {
+				return "", 0, err
+			}
+		}
+		return "", 0, errEndOfList
+	}
+	var hdr [8]byte
+	if err := r.read(hdr[:]); err != nil {
+		return "", 0, err
+	}
+	size := int64(binary.LittleEndian.Uint32(hdr[4:8]))
+	if r.off+size > r.ends[len(r.ends)-1] {
+		return "", 0, ErrCorrupt
+	}
+	return string(hdr[0:4]), size, nil
+}
+
+func (r *Reader) readHeaders() error {
+	depth := len(r.ends)
+	streamNo := 0
+	var isVideo bool
+	for {
+		id, size, err := r.chunk()
+		if err == errEndOfList {
+			if len(r.ends) < depth {
+				return nil
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if id == "LIST" {
+			var typ [4]byte
+			if err := r.read(typ[:]); err != nil {
+				return err
+			}
+			if string(typ[:]) == "strl" {
+				isVideo = false
+				r.ends = append(r.ends, r.off+size-4)
+				continue
+			}
+			if err := r.skip(size - 4); err != nil {
+				return err
+			}
+			continue
+		}
+		if id != "avih" && id != "strh" && id != "strf" {
+			if err := r.skip(size); err != nil {
+				return err
+			}
+			continue
+		}
+		if size > maxHeaderChunk {
+			return ErrCorrupt
+		}
+
+		b := make([]byte, size)
+		if err := r.read(b); err != nil {
+			return err
+		}
+		if size%2 == 1 {
+			if err := r.discard(1); err != nil {
+				return err
+			}
+		}
+		switch id {
+		case "avih":
+			if len(b) >= 40 {
+				r.frameDuration = time.Duration(binary.LittleEndian.Uint32(b[0:4])) * time.Microsecond
+				r.totalFrames = int(binary.LittleEndian.Uint32(b[16:20]))
+				r.width = int(binary.LittleEndian.Uint32(b[32:36]))
+				r.height = int(binary.LittleEndian.Uint32(b[36:40]))
+			}
+		case "strh":
+			if len(b) >= 28 && string(b[0:4]) == "vids" && r.stream == "" {
+				isVideo = true
+				if mjpegFourCCs[strings.ToUpper(string(b[4:8]))] {
+					r.stream = fmt.Sprintf("%02d", streamNo)
+				}
+				scale := binary.LittleEndian.Uint32(b[20:24])
+				rate := binary.LittleEndian.Uint32(b[24:28])
+				if rate > 0 && scale > 0 {
+					r.frameDuration = time.Duration(float64(time.Second) * float64(scale) / float64(rate))
+				}
+			}
+			streamNo++
+		case "strf":
+			// The handler in strh is often empty, the compression of the
+			// bitmap header tells the codec then.
+			if isVideo && r.stream == "" && len(b) >= 20 && mjpegFourCCs[strings.ToUpper(string(b[16:20]))] {
+				r.stream = fmt.Sprintf("%02d", streamNo-1)
+			}
+		}
+	}
+}
+
+// Next return the next frame of the video stream, or io.EOF at the end of the movie data.
+// Frame.Time is the presentation time of the frame relative to the zero time.
+func (r *Reader) Next() (*mjpeg.Frame, error) {
+	for {
+		id, size, err := r.chunk()
+		if err == errEndOfList {
+			if len(r.ends) > 0 {
+				continue
+			}
+			// Files over 1GB continue in AVIX RIFF chunks.
+			if err := r.nextRIFF(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if id == "LIST" {
+			// movi in AVIX and "rec " lists group chunks, descend into them.
+			var typ [4]byte
+			if err := r.read(typ[:]); err != nil {
+				return nil, err
+			}
+			r.ends = append(r.ends, r.off+size-4)
+			continue
+		}
+		if len(id) != 4 || id[:2] != r.stream || (id[2:] != "dc" && id[2:] != "db") || size == 0 {
+			if err := r.skip(size); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if r.maxFrameSize > 0 && size > int64(r.maxFrameSize) {
+			if err := r.skip(size); err != nil {
+				return nil, err
+			}
+			return nil, &mjpeg.FrameSizeError{Limit: r.maxFrameSize}
+		}
+
+		b := make([]byte, size)
+		if err := r.read(b); err != nil {
+			return nil, err
+		}
+		if size%2 == 1 {
+			if err := r.discard(1); err != nil {
+				return nil, err
+			}
+		}
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Type", "image/jpeg")
+		f := &mjpeg.Frame{
+			Data:   withHuffmanTables(b),
+			Header: header,
+			Seq:    r.seq + 1,
+			Time:   time.Time{}.Add(time.Duration(r.seq) * r.frameDuration),
+		}
+		r.seq++
+		return f, nil
+	}
+}
+
+func (r *Reader) nextRIFF() error {
+	var hdr [12]byte
+	if err := r.read(hdr[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return io.EOF
+		}
+		return err
+	}
+	if string(hdr[0:4]) != "RIFF" || string(hdr[8:12]) != "AVIX" {
+		return io.EOF
+	}
+	r.ends = append(r.ends, r.off-4+int64(binary.LittleEndian.Uint32(hdr[4:8])))
+	return nil
+}
+
+// All return an iterator over the frames, see mjpeg.Decoder.All.
+func (r *Reader) All() iter.Seq2[*mjpeg.Frame, error] {
+	return func(yield func(*mjpeg.Frame, error) bool) {
+		for {
+			f, err := r.Next()
+			if err == io.EOF {
+				return
+			}
+			if !yield(f, err) || err != nil {
+				return
+			}
+		}
+	}
+}
+
+// defaultDHT is the DHT segment with the standard Huffman tables of the JPEG
+// spec (K.3), which image/jpeg writes into every image.
+var defaultDHT = func() []byte {
+	var b bytes.Buffer
+	jpeg.Encode(&b, image.NewYCbCr(image.Rect(0, 0, 8, 8), image.YCbCrSubsampleRatio420), nil)
+	data := b.Bytes()
+	i := bytes.Index(data, []byte{0xFF, 0xC4})
+	n := int(data[i+2])<<8 | int(data[i+3])
+	return append([]byte(nil), data[i:i+2+n]...)
+}()
+
+// withHuffmanTables inserts the standard Huffman tables which AVI1 motion jpeg
+// omits, image/jpeg cannot decode such frames otherwise.
+func withHuffmanTables(b []byte) []byte {
+	if len(b) < 4 || b[0] != 0xFF || b[1] != 0xD8 {
+		return b
+	}
+	i := 2
+	for i+4 <= len(b) && b[i] == 0xFF {
+		code := b[i+1]
+		if code == 0xC4 {
+			return b
+		}
+		if code == 0xDA {
+			out := make([]byte, 0, len(b)+len(defaultDHT))
+			out = append(out, b[:i]...)
+			out = append(out, defaultDHT...)
+			return append(out, b[i:]...)
+		}
+		i += 2 + (int(b[i+2])<<8 | int(b[i+3]))
+	}
+	return b
+}
@@ -0,0 +1,81 @@
+package avi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/WarehouseRobotics/go-mjpeg"
+)
+
+func chunk(id string, data []byte) []byte {
+	b := binary.LittleEndian.AppendUint32([]byte(id), uint32(len(data)))
+	b = append(b, data...)
+	if len(data)%2 == 1 {
+		b = append(b, 0)
+	}
+	return b
+}
+
+func list(typ string, chunks ...[]byte) []byte {
+	return chunk("LIST", append([]byte(typ), bytes.Join(chunks, nil)...))
+}
+
+// testAVI returns an AVI file of a MJPG stream with the chunks of movi.
+func testAVI(movi ...[]byte) []byte {
+	strh := make([]byte, 56)
+	copy(strh, "vidsMJPG")
+	binary.LittleEndian.PutUint32(strh[20:], 1)
+	binary.LittleEndian.PutUint32(strh[24:], 25)
+	hdrl := list("hdrl",
+		chunk("avih", make([]byte, 56)),
+		list("strl", chunk("strh", strh), chunk("strf", make([]byte, 40))))
+	return chunk("RIFF", append([]byte("AVI "), append(hdrl, list("movi", movi...)...)...))
+}
+
+func TestReader(t *testing.T) {
+	frames := [][]byte{[]byte("\xFF\xD8one\xFF\xD9"), []byte("\xFF\xD8frame two\xFF\xD9")}
+	r, err := NewReader(bytes.NewReader(testAVI(chunk("00dc", frames[0]), chunk("01wb", []byte("audio")), chunk("00dc", frames[1]))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range frames {
+		f, err := r.Next()
+		if err != nil {
+			t.Fatalf("frame %d: %v", i, err)
+		}
+		if !bytes.Equal(f.Data, want) {
+			t.Errorf("frame %d = %q, want %q", i, f.Data, want)
+		}
+	}
+	if _, err := r.Next(); err != io.EOF {
+		t.Errorf("err = %v, want io.EOF", err)
+	}
+}
+
+func TestReaderChunkSize(t *testing.T) {
+	// A size of 4 GiB in a chunk of a few bytes.
+	bad := binary.LittleEndian.AppendUint32([]byte("00dc"), 0xFFFFFFF0)
+	r, err := NewReader(bytes.NewReader(testAVI(append(bad, "\xFF\xD8\xFF\xD9"...))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Next(); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("err = %v, want ErrCorrupt", err)
+	}
+
+	big := bytes.Repeat([]byte{0}, 100)
+	r, err = NewReader(bytes.NewReader(testAVI(chunk("00dc", big), chunk("00dc", []byte("\xFF\xD8\xFF\xD9")))), WithMaxFrameSize(50))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fse *mjpeg.FrameSizeError
+	if _, err := r.Next(); !errors.As(err, &fse) {
+		t.Fatalf("err = %v, want *mjpeg.FrameSizeError", err)
+	}
+	if _, err := r.Next(); err != nil {
+		t.Errorf("frame after the skipped one: %v", err)
+	}
+}
@@ -0,0 +1,42 @@
+package mjpeg
+
+import (
+	"time"
+)
+
+// WithMaxBandwidth limit the bytes per second written to each viewer of
+// ServeHTTP. Frames exceeding the budget are dropped rather than queued, so
+// that a viewer on a slow link gets fewer frames instead of late ones.
+func WithMaxBandwidth(bytesPerSec int) StreamOption {
+	return func(s *Stream) {
+		s.bandwidth = bytesPerSec
+	}
+}
+
+// tokenBucket holds up to one second of budget. A frame larger than that is
+// let through when the bucket is full and leaves it in debt.
+type tokenBucket struct {
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(bytesPerSec int) *tokenBucket {
+	return &tokenBucket{
+		rate:   float64(bytesPerSec),
+		tokens: float64(bytesPerSec),
+		last:   time.Now(),
+	}
+}
+
+// allow reports whether n bytes can be written now, and takes them.
+func (b *tokenBucket) allow(n int) bool {
+	now := time.Now()
+	b.tokens = min(b.rate, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	if b.tokens < float64(n) && b.tokens < b.rate {
+		return false
+	}
+	b.tokens -= float64(n)
+	return true
+}
@@ -0,0 +1,66 @@
+package mjpeg
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestReconnectSharesTransport(t *testing.T) {
+	s := NewStream()
+	s.Update(gradientJPEG(t, 16, 16))
+	srv := httptest.NewTLSServer(s)
+	defer srv.Close()
+	// The handlers return once the stream is closed.
+	defer s.Close()
+
+	tlsConfig := srv.Client().Transport.(*http.Transport).TLSClientConfig
+	d, err := NewReconnectingDecoder(srv.URL, WithTLSConfig(tlsConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	for range 2 {
+		c, err := d.connect()
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.Close()
+		if c.opts.httpc != d.opts.httpc {
+			t.Fatal("connection made with a new client")
+		}
+	}
+	if d.opts.transport == nil || d.opts.httpc.Transport != d.opts.transport {
+		t.Fatal("no Transport of its own for WithTLSConfig")
+	}
+}
+
+func TestReconnectSharesProxyTransport(t *testing.T) {
+	s := NewStream()
+	s.Update(gradientJPEG(t, 16, 16))
+	// The stream stands for the camera behind an HTTP proxy.
+	proxy := httptest.NewServer(s)
+	defer proxy.Close()
+	defer s.Close()
+
+	u, _ := url.Parse(proxy.URL)
+	d, err := NewReconnectingDecoder("http://camera.invalid/", WithProxy(u))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	for range 2 {
+		c, err := d.connect()
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.Close()
+		if c.opts.httpc != d.opts.httpc {
+			t.Fatal("connection made with a new client")
+		}
+	}
+	if d.opts.transport == nil || d.opts.transport.Proxy == nil {
+		t.Fatal("no proxy Transport")
+	}
+}
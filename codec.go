@@ -0,0 +1,83 @@
+package mjpeg
+
+import (
+	"bytes"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+)
+
+// Codec decodes and encodes JPEG images. It allows replacing image/jpeg with
+// a faster implementation, e.g. bindings of libjpeg-turbo.
+type Codec interface {
+	Decode(r io.Reader) (image.Image, error)
+	Encode(w io.Writer, m image.Image, o *jpeg.Options) error
+}
+
+// DefaultCodec is the Codec used when none is configured. It uses image/jpeg.
+var DefaultCodec Codec = stdCodec{}
+
+type stdCodec struct{}
+
+func (stdCodec) Decode(r io.Reader) (image.Image, error) {
+	return jpeg.Decode(r)
+}
+
+func (stdCodec) Encode(w io.Writer, m image.Image, o *jpeg.Options) error {
+	return jpeg.Encode(w, m, o)
+}
+
+// ConfigDecoder is implemented by a Codec which can read the image
+// configuration without decoding the image.
+type ConfigDecoder interface {
+	DecodeConfig(r io.Reader) (image.Config, error)
+}
+
+func (stdCodec) DecodeConfig(r io.Reader) (image.Config, error) {
+	return jpeg.DecodeConfig(r)
+}
+
+// WithCodec set the Codec used to decode frames.
+func WithCodec(c Codec) DecoderOption {
+	return func(o *decoderOptions) {
+		o.codec = c
+	}
+}
+
+// decodeImage decodes b with c when it is JPEG. Other formats, e.g. PNG
+// parts of a screen capture stream, are decoded by image.Decode, which knows
+// the formats registered with image.RegisterFormat.
+func decodeImage(c Codec, b []byte) (image.Image, error) {
+	if isJPEG(b) {
+		return c.Decode(bytes.NewReader(b))
+	}
+	img, _, err := image.Decode(bytes.NewReader(b))
+	return img, err
+}
+
+// decodeImageConfig is like decodeImage for the image configuration.
+func decodeImageConfig(c Codec, b []byte) (image.Config, error) {
+	r := bytes.NewReader(b)
+	if !isJPEG(b) {
+		cfg, _, err := image.DecodeConfig(r)
+		return cfg, err
+	}
+	if c, ok := c.(ConfigDecoder); ok {
+		return c.DecodeConfig(r)
+	}
+	return jpeg.DecodeConfig(r)
+}
+
+// isJPEG reports whether b starts with a JPEG SOI marker.
+func isJPEG(b []byte) bool {
+	return len(b) >= 2 && b[0] == 0xFF && b[1] == 0xD8
+}
+
+func (o *decoderOptions) jpegCodec() Codec {
+	if o.codec != nil {
+		return o.codec
+	}
+	return DefaultCodec
+}
@@ -0,0 +1,58 @@
+package mjpeg
+
+import (
+	"errors"
+	"mime"
+	"strings"
+)
+
+type streamKind int
+
+const (
+	kindUnknown streamKind = iota
+	kindMultipart
+	kindJPEG
+)
+
+// contentTypes maps media types sent by cameras to the framing of the body.
+// Types which are not listed are accepted when they carry a boundary.
+var contentTypes = map[string]streamKind{
+	"multipart/x-mixed-replace": kindMultipart,
+	"multipart/mixed":           kindMultipart,
+	"multipart/related":         kindMultipart,
+	"multipart/x-mixed":         kindMultipart,
+	"multipart/form-data":       kindMultipart,
+	"image/jpeg":                kindJPEG,
+	"image/jpg":                 kindJPEG,
+	"image/pjpeg":               kindJPEG,
+	// Motion JPEG types used by some vendors both for multipart and for
+	// concatenated JPEG bodies, the body decides.
+	"video/x-motion-jpeg": kindUnknown,
+	"video/x-mjpeg":       kindUnknown,
+	"video/mjpeg":         kindUnknown,
+}
+
+var errNoContentType = errors.New("mjpeg: no content type")
+
+// parseContentType returns the media type and the boundary of a Content-Type
+// header. Values mime.ParseMediaType rejects, like the unquoted boundary with
+// spaces of D-Link cameras ("boundary=--video boundary--"), are split by hand.
+func parseContentType(v string) (mediatype, boundary string, err error) {
+	mediatype, param, err := mime.ParseMediaType(v)
+	if err == nil {
+		return mediatype, param["boundary"], nil
+	}
+
+	fields := strings.Split(v, ";")
+	mediatype = strings.ToLower(strings.TrimSpace(fields[0]))
+	if mediatype == "" {
+		return "", "", errNoContentType
+	}
+	for _, f := range fields[1:] {
+		f = strings.TrimSpace(f)
+		if len(f) > 9 && strings.EqualFold(f[:9], "boundary=") {
+			boundary = strings.Trim(strings.TrimSpace(f[9:]), `"`)
+		}
+	}
+	return mediatype, boundary, nil
+}
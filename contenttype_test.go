@@ -0,0 +1,117 @@
+package mjpeg
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestParseContentType(t *testing.T) {
+	tests := []struct {
+		v         string
+		mediatype string
+		boundary  string
+		err       error
+	}{
+		{"multipart/x-mixed-replace; boundary=frame", "multipart/x-mixed-replace", "frame", nil},
+		{"Multipart/X-Mixed-Replace; Boundary=frame", "multipart/x-mixed-replace", "frame", nil},
+		{`multipart/x-mixed-replace; boundary="my frame"`, "multipart/x-mixed-replace", "my frame", nil},
+		{`multipart/x-mixed-replace; boundary="--video boundary--"`, "multipart/x-mixed-replace", "--video boundary--", nil},
+		{"multipart/x-mixed-replace;boundary=--video boundary--", "multipart/x-mixed-replace", "--video boundary--", nil},
+		{"multipart/mixed; boundary=frame", "multipart/mixed", "frame", nil},
+		{"video/x-mjpeg", "video/x-mjpeg", "", nil},
+		{"multipart/x-mixed-replace", "multipart/x-mixed-replace", "", nil},
+		{"", "", "", errNoContentType},
+	}
+	for _, tt := range tests {
+		mediatype, boundary, err := parseContentType(tt.v)
+		if mediatype != tt.mediatype || boundary != tt.boundary || !errors.Is(err, tt.err) {
+			t.Errorf("parseContentType(%q) = %q, %q, %v, want %q, %q, %v",
+				tt.v, mediatype, boundary, err, tt.mediatype, tt.boundary, tt.err)
+		}
+	}
+}
+
+// multipartBody returns the parts of frames separated by the boundary line
+// delim. The last part ends with the next boundary, like in a live stream.
+func multipartBody(delim string, frames ...[]byte) []byte {
+	var b bytes.Buffer
+	for _, f := range frames {
+		fmt.Fprintf(&b, "%s\r\nContent-Type: image/jpeg\r\nContent-Length: %d\r\n\r\n", delim, len(f))
+		b.Write(f)
+		b.WriteString("\r\n")
+	}
+	b.WriteString(delim + "\r\n")
+	return b.Bytes()
+}
+
+func TestNewDecoderFromResponse(t *testing.T) {
+	frames := [][]byte{gradientJPEG(t, 16, 16), gradientJPEG(t, 32, 16)}
+	tests := []struct {
+		name string
+		ct   string
+		body []byte
+	}{
+		{"boundary", "multipart/x-mixed-replace; boundary=frame", multipartBody("--frame", frames...)},
+		{"quoted boundary", `multipart/x-mixed-replace; boundary="my frame"`, multipartBody("--my frame", frames...)},
+		{"dashed boundary", "multipart/x-mixed-replace;boundary=--video boundary--", multipartBody("--video boundary--", frames...)},
+		{"dashed boundary, fewer dashes in body", "multipart/x-mixed-replace; boundary=--frame", multipartBody("--frame", frames...)},
+		{"multipart/mixed", "multipart/mixed; boundary=frame", multipartBody("--frame", frames...)},
+		{"video/x-mjpeg multipart", "video/x-mjpeg", multipartBody("--frame", frames...)},
+		{"video/x-mjpeg concatenated", "video/x-mjpeg", bytes.Join(frames, nil)},
+		{"image/jpeg", "image/jpeg", bytes.Join(frames, nil)},
+		{"missing boundary", "multipart/x-mixed-replace", multipartBody("--frame", frames...)},
+		{"missing content type", "", multipartBody("--frame", frames...)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := &http.Response{
+				Header: http.Header{"Content-Type": {tt.ct}},
+				Body:   io.NopCloser(bytes.NewReader(tt.body)),
+			}
+			d, err := NewDecoderFromResponse(res)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer d.Close()
+			for i, want := range frames {
+				got, _, err := d.DecodeRaw()
+				if err != nil {
+					t.Fatalf("frame %d: %v", i, err)
+				}
+				if !bytes.Equal(got, want) {
+					t.Fatalf("frame %d: got %d bytes, want %d", i, len(got), len(want))
+				}
+			}
+		})
+	}
+}
+
+func TestNewDecoderFromResponseErrors(t *testing.T) {
+	body := []byte("neither multipart nor JPEG")
+	tests := []struct {
+		name string
+		ct   string
+		want string
+	}{
+		{"missing content type", "", errNoContentType.Error()},
+		{"unknown content type", "application/octet-stream", `"application/octet-stream"`},
+		{"multipart without boundary", "multipart/x-mixed-replace", `"multipart/x-mixed-replace"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := &http.Response{
+				Header: http.Header{"Content-Type": {tt.ct}},
+				Body:   io.NopCloser(bytes.NewReader(body)),
+			}
+			_, err := NewDecoderFromResponse(res)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("err = %v, want one with %s", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package mjpeg
+
+import (
+	"net/http"
+)
+
+// WithCORS allows browser apps of origins to consume the stream. An origin
+// "*" allows any origin.
+func WithCORS(origins ...string) StreamOption {
+	return func(s *Stream) {
+		s.origins = append(s.origins, origins...)
+	}
+}
+
+// allowedOrigin returns the value of Access-Control-Allow-Origin for origin,
+// or "" when it is not allowed.
+func (s *Stream) allowedOrigin(origin string) string {
+	for _, o := range s.origins {
+		if o == "*" {
+			return "*"
+		}
+		if o == origin {
+			return origin
+		}
+	}
+	return ""
+}
+
+// cors sets the CORS headers of the response. It returns true when r is an
+// OPTIONS request, which was answered: 403 Forbidden for an origin which is
+// not allowed, 204 No Content otherwise.
+func (s *Stream) cors(w http.ResponseWriter, r *http.Request) bool {
+	if len(s.origins) == 0 {
+		return false
+	}
+	w.Header().Add("Vary", "Origin")
+	origin := r.Header.Get("Origin")
+	allowed := s.allowedOrigin(origin)
+	if origin != "" && allowed != "" {
+		w.Header().Set("Access-Control-Allow-Origin", allowed)
+	}
+	if r.Method != http.MethodOptions {
+		return false
+	}
+	if origin != "" && allowed == "" {
+		w.WriteHeader(http.StatusForbidden)
+		return true
+	}
+	w.Header().Set("Allow", "GET, HEAD, OPTIONS")
+	if origin != "" && r.Header.Get("Access-Control-Request-Method") != "" {
+		w.Header().Set("Access-Control-Allow-Methods", "GET, HEAD, OPTIONS")
+		if h := r.Header.Get("Access-Control-Request-Headers"); h != "" {
+			w.Header().Set("Access-Control-Allow-Headers", h)
+		}
+		w.Header().Set("Access-Control-Max-Age", "600")
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return true
+}
@@ -0,0 +1,40 @@
+package mjpeg
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCORSOptions(t *testing.T) {
+	s := NewStream(WithCORS("https://app.example"))
+	defer s.Close()
+	tests := []struct {
+		name        string
+		header      http.Header
+		status      int
+		allowOrigin string
+	}{
+		{"preflight", http.Header{"Origin": {"https://app.example"}, "Access-Control-Request-Method": {"GET"}}, http.StatusNoContent, "https://app.example"},
+		{"disallowed origin", http.Header{"Origin": {"https://evil.example"}, "Access-Control-Request-Method": {"GET"}}, http.StatusForbidden, ""},
+		{"allowed origin without method", http.Header{"Origin": {"https://app.example"}}, http.StatusNoContent, "https://app.example"},
+		{"bare OPTIONS", http.Header{}, http.StatusNoContent, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodOptions, "/", nil)
+			r.Header = tt.header
+			w := httptest.NewRecorder()
+			s.ServeHTTP(w, r)
+			if w.Code != tt.status {
+				t.Errorf("status %d, want %d", w.Code, tt.status)
+			}
+			if got := w.Header().Get("Access-Control-Allow-Origin"); got != tt.allowOrigin {
+				t.Errorf("Access-Control-Allow-Origin %q, want %q", got, tt.allowOrigin)
+			}
+			if w.Body.Len() != 0 {
+				t.Errorf("body of %d bytes", w.Body.Len())
+			}
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package mjpeg
+
+import (
+	"image"
+	"net/http"
+)
+
+// WithCrop crops the frames to r when they are published, e.g. to make a
+// Stream of a zone of a wide-angle camera. r is in the coordinates of the
+// frames after WithOrientation, whose top-left corner is 0,0.
+func WithCrop(r image.Rectangle) StreamOption {
+	return func(s *Stream) {
+		s.crop = r
+	}
+}
+
+// WithClientRegions lets the viewers of ServeHTTP ask for a zone of the
+// frames with the query parameter region, e.g. ?region=dock1 for
+// regions["dock1"], so that one camera serves several zones. Like for
+// WithClientWidths, the frames are cropped once per region and shared by
+// the viewers. Other widths are then relative to the region.
+func WithClientRegions(regions map[string]image.Rectangle) StreamOption {
+	return func(s *Stream) {
+		s.regions = make(map[string]image.Rectangle, len(regions))
+		for name, r := range regions {
+			s.regions[name] = r
+		}
+	}
+}
+
+// clientRegion returns the region asked by the query of r, "" for the
+// whole frame.
+func (s *Stream) clientRegion(r *http.Request) string {
+	name := r.URL.Query().Get("region")
+	if _, ok := s.regions[name]; !ok {
+		return ""
+	}
+	return name
+}
+
+// cropped returns the part of img in r, relative to its top-left corner.
+func cropped(img image.Image, r image.Rectangle) image.Image {
+	b := img.Bounds()
+	r = r.Add(b.Min).Intersect(b)
+	if r.Empty() || r == b {
+		return img
+	}
+	if sub, ok := img.(interface {
+		SubImage(image.Rectangle) image.Image
+	}); ok {
+		return sub.SubImage(r)
+	}
+	return rgba(img).SubImage(r.Sub(b.Min))
+}
@@ -0,0 +1,26 @@
+package mjpeg
+
+import (
+	"hash/maphash"
+	"time"
+)
+
+// WithDuplicateSuppression makes Update skip frames identical to the
+// previous one, as sent by static scenes. A duplicate is still broadcast when
+// nothing was for max, unless max is 0.
+func WithDuplicateSuppression(max time.Duration) StreamOption {
+	return func(s *Stream) {
+		s.dedup = true
+		s.dedupMax = max
+		s.seed = maphash.MakeSeed()
+	}
+}
+
+// duplicate reports whether the frame of hash h, published at now, is to be
+// skipped. It is called with s.m held.
+func (s *Stream) duplicate(h uint64, now time.Time) bool {
+	if s.last == nil || h != s.lastHash {
+		return false
+	}
+	return s.dedupMax == 0 || now.Sub(s.last.Time) < s.dedupMax
+}
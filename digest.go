@@ -0,0 +1,181 @@
+package mjpeg
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"net/http"
+	"strings"
+)
+
+// WithDigestAuth set the credentials sent with the request using HTTP Digest
+// authentication. The first request is sent without credentials and answered
+// to the 401 challenge of the camera.
+func WithDigestAuth(username, password string) DecoderOption {
+	return func(o *decoderOptions) {
+		o.username = username
+		o.password = password
+		o.digest = true
+	}
+}
+
+type digestChallenge struct {
+	realm     string
+	nonce     string
+	opaque    string
+	algorithm string
+	qop       string
+}
+
+func parseDigestChallenge(s string) (*digestChallenge, error) {
+	if len(s) < 7 || !strings.EqualFold(s[:7], "digest ") {
+		return nil, errors.New("mjpeg: not a digest challenge")
+	}
+	params := parseAuthParams(s[7:])
+	c := &digestChallenge{
+		realm:     params["realm"],
+		nonce:     params["nonce"],
+		opaque:    params["opaque"],
+		algorithm: params["algorithm"],
+	}
+	if c.nonce == "" {
+		return nil, errors.New("mjpeg: digest challenge without nonce")
+	}
+	if qop, ok := params["qop"]; ok {
+		for _, q := range strings.Split(qop, ",") {
+			if strings.TrimSpace(q) == "auth" {
+				c.qop = "auth"
+			}
+		}
+		if c.qop == "" {
+			return nil, fmt.Errorf("mjpeg: unsupported digest qop %q", qop)
+		}
+	}
+	return c, nil
+}
+
+// parseAuthParams parses comma separated key=value pairs where values may be quoted.
+func parseAuthParams(s string) map[string]string {
+	params := map[string]string{}
+	for {
+		s = strings.TrimLeft(s, " \t,")
+		if s == "" {
+			return params
+		}
+		eq := strings.IndexByte(s, '=')
+		if eq < 0 {
+			return params
+		}
+		key := strings.ToLower(strings.TrimSpace(s[:eq]))
+		s = strings.TrimLeft(s[eq+1:], " \t")
+
+		var val string
+		if strings.HasPrefix(s, `"`) {
+			var b strings.Builder
+			i := 1
+			for ; i < len(s) && s[i] != '"'; i++ {
+				if s[i] == '\\' && i+1 < len(s) {
+					i++
+				}
+				b.WriteByte(s[i])
+			}
+			val = b.String()
+			if i < len(s) {
+				i++
+			}
+			s = s[i:]
+		} else {
+			end := strings.IndexByte(s, ',')
+			if end < 0 {
+				end = len(s)
+			}
+			val = strings.TrimSpace(s[:end])
+			s = s[end:]
+		}
+		params[key] = val
+	}
+}
+
+// authorize returns the value of the Authorization header answering the challenge.
+func (c *digestChallenge) authorize(method, uri, username, password string) (string, error) {
+	var h func() hash.Hash
+	algorithm := strings.ToUpper(c.algorithm)
+	switch strings.TrimSuffix(algorithm, "-SESS") {
+	case "", "MD5":
+		h = md5.New
+	case "SHA-256":
+		h = sha256.New
+	default:
+		return "", fmt.Errorf("mjpeg: unsupported digest algorithm %q", c.algorithm)
+	}
+	sum := func(s string) string {
+		hh := h()
+		hh.Write([]byte(s))
+		return hex.EncodeToString(hh.Sum(nil))
+	}
+
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	cnonce := hex.EncodeToString(b[:])
+	const nc = "00000001"
+
+	ha1 := sum(username + ":" + c.realm + ":" + password)
+	if strings.HasSuffix(algorithm, "-SESS") {
+		ha1 = sum(ha1 + ":" + c.nonce + ":" + cnonce)
+	}
+	ha2 := sum(method + ":" + uri)
+
+	var response string
+	if c.qop != "" {
+		response = sum(strings.Join([]string{ha1, c.nonce, nc, cnonce, c.qop, ha2}, ":"))
+	} else {
+		response = sum(ha1 + ":" + c.nonce + ":" + ha2)
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, `Digest username=%q, realm=%q, nonce=%q, uri=%q, response=%q`,
+		username, c.realm, c.nonce, uri, response)
+	if c.algorithm != "" {
+		fmt.Fprintf(&sb, `, algorithm=%s`, c.algorithm)
+	}
+	if c.opaque != "" {
+		fmt.Fprintf(&sb, `, opaque=%q`, c.opaque)
+	}
+	if c.qop != "" {
+		fmt.Fprintf(&sb, `, qop=%s, nc=%s, cnonce=%q`, c.qop, nc, cnonce)
+	}
+	return sb.String(), nil
+}
+
+// digestRetry answers the 401 challenge in res by sending req again with credentials.
+func (o *decoderOptions) digestRetry(c *http.Client, req *http.Request, res *http.Response) (*http.Response, error) {
+	var challenge *digestChallenge
+	for _, v := range res.Header.Values("WWW-Authenticate") {
+		if ch, err := parseDigestChallenge(v); err == nil {
+			challenge = ch
+			break
+		}
+	}
+	if challenge == nil {
+		return res, nil
+	}
+	res.Body.Close()
+
+	// Answer the request which was challenged, it differs after a redirect.
+	if res.Request != nil {
+		req = res.Request
+	}
+	auth, err := challenge.authorize(req.Method, req.URL.RequestURI(), o.username, o.password)
+	if err != nil {
+		return nil, err
+	}
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", auth)
+	return c.Do(req)
+}
@@ -0,0 +1,81 @@
+package mjpeg
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+)
+
+// WithQuality sets the JPEG quality used by UpdateImage, from 1 to 100.
+func WithQuality(q int) StreamOption {
+	return func(s *Stream) {
+		s.quality = q
+	}
+}
+
+// WithEncoder sets the Codec encoding the frames of UpdateImage, instead of
+// DefaultCodec. It allows e.g. choosing the chroma subsampling, which
+// image/jpeg does not. Encoders must produce baseline JPEG, many viewers do
+// not render progressive frames in a multipart stream.
+func WithEncoder(c Codec) StreamOption {
+	return func(s *Stream) {
+		s.encoder = c
+	}
+}
+
+// SetQuality changes the JPEG quality at runtime, e.g. to reduce it under load.
+func (s *Stream) SetQuality(q int) {
+	s.encM.Lock()
+	s.quality = q
+	s.encM.Unlock()
+}
+
+// Quality returns the JPEG quality used by UpdateImage.
+func (s *Stream) Quality() int {
+	s.encM.Lock()
+	defer s.encM.Unlock()
+	return s.jpegOptions().Quality
+}
+
+// SetEncoder changes the Codec used by UpdateImage at runtime.
+func (s *Stream) SetEncoder(c Codec) {
+	s.encM.Lock()
+	s.encoder = c
+	s.encM.Unlock()
+}
+
+// UpdateImage encodes img as JPEG and sends it like Update.
+func (s *Stream) UpdateImage(img image.Image) error {
+	b, err := s.encode(img)
+	if err != nil {
+		return err
+	}
+	return s.Update(b)
+}
+
+// encode returns img as JPEG. The encoding buffer is kept between calls so
+// that it does not grow again for each frame, the result is a copy since the
+// clients keep a reference to it.
+func (s *Stream) encode(img image.Image) ([]byte, error) {
+	s.encM.Lock()
+	defer s.encM.Unlock()
+
+	c := s.encoder
+	if c == nil {
+		c = DefaultCodec
+	}
+	s.enc.Reset()
+	if err := c.Encode(&s.enc, img, s.jpegOptions()); err != nil {
+		return nil, err
+	}
+	return bytes.Clone(s.enc.Bytes()), nil
+}
+
+// jpegOptions returns the encoding options, it is called with s.encM held.
+func (s *Stream) jpegOptions() *jpeg.Options {
+	q := s.quality
+	if q == 0 {
+		q = jpeg.DefaultQuality
+	}
+	return &jpeg.Options{Quality: q}
+}
@@ -0,0 +1,89 @@
+package mjpeg
+
+import (
+	"context"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ServeEvents sends the frames to the viewer of r as Server-Sent Events, for
+// the networks where neither multipart nor WebSockets get through. Each
+// frame is a "frame" event whose data is a JSON object with the fields seq,
+// time and jpeg, the base64 of the frame:
+//
+//	id: 42
+//	event: frame
+//	data: {"seq":42,"time":"2024-05-01T10:00:00.04Z","jpeg":"/9j/4AAQ..."}
+//
+// An "end" event tells that the stream was closed, so that EventSource does
+// not reconnect. The policies of ServeHTTP apply.
+func (s *Stream) ServeEvents(w http.ResponseWriter, r *http.Request) {
+	s.serve(w, r, s.openEvents)
+}
+
+// openEvents starts the text/event-stream response of ServeEvents.
+func (s *Stream) openEvents(w http.ResponseWriter, r *http.Request, c *client) (viewerConn, context.Context, error) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// nginx buffers the responses of its upstreams unless told otherwise.
+	w.Header().Set("X-Accel-Buffering", "no")
+	return &eventsConn{
+		w:   w,
+		rc:  http.NewResponseController(w),
+		buf: partBufs.Get().(*[]byte),
+	}, r.Context(), nil
+}
+
+// eventsConn is the response of ServeEvents, each event is written with a
+// single Write from a buffer taken from partBufs.
+type eventsConn struct {
+	w   io.Writer
+	rc  *http.ResponseController
+	buf *[]byte
+}
+
+func (e *eventsConn) writePart(f *Frame, _ time.Time) (int, error) {
+	b := (*e.buf)[:0]
+	b = append(b, "id: "...)
+	b = strconv.AppendUint(b, f.Seq, 10)
+	b = append(b, "\nevent: frame\ndata: {\"seq\":"...)
+	b = strconv.AppendUint(b, f.Seq, 10)
+	b = append(b, `,"time":"`...)
+	b = f.Time.UTC().AppendFormat(b, time.RFC3339Nano)
+	b = append(b, `","jpeg":"`...)
+	b = base64.StdEncoding.AppendEncode(b, f.Data)
+	b = append(b, "\"}\n\n"...)
+	*e.buf = b
+	if _, err := e.w.Write(b); err != nil {
+		return 0, err
+	}
+	return len(f.Data), nil
+}
+
+// close sends the end event.
+func (e *eventsConn) close() error {
+	_, err := io.WriteString(e.w, "event: end\ndata: {}\n\n")
+	return err
+}
+
+func (e *eventsConn) flush() error {
+	return flush(e.rc)
+}
+
+func (e *eventsConn) setWriteDeadline(t time.Time) error {
+	return e.rc.SetWriteDeadline(t)
+}
+
+// release returns the write buffer to partBufs.
+func (e *eventsConn) release() {
+	if e.buf == nil {
+		return
+	}
+	if cap(*e.buf) <= maxPartBuf {
+		partBufs.Put(e.buf)
+	}
+	e.buf = nil
+}
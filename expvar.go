@@ -0,0 +1,24 @@
+package mjpeg
+
+import "expvar"
+
+// PublishExpvar publishes the Stats and the Watchers of the stream as the
+// expvar name, evaluated on every request to /debug/vars. Like
+// expvar.Publish, it panics if the name is already in use.
+func (s *Stream) PublishExpvar(name string) {
+	expvar.Publish(name, expvar.Func(func() any {
+		return struct {
+			StreamStats
+			Watchers []WatcherInfo
+		}{s.Stats(), s.Watchers()}
+	}))
+}
+
+// PublishExpvar publishes the Stats of the decoder as the expvar name,
+// evaluated on every request to /debug/vars. Like expvar.Publish, it panics
+// if the name is already in use.
+func (d *Decoder) PublishExpvar(name string) {
+	expvar.Publish(name, expvar.Func(func() any {
+		return d.Stats()
+	}))
+}
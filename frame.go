@@ -0,0 +1,78 @@
+package mjpeg
+
+import (
+	"image"
+	"net/textproto"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Frame is a single JPEG frame with its metadata
+type Frame struct {
+	// Data is the raw JPEG bytes of the frame.
+	Data []byte
+	// Header is the MIME header of the part which carried the frame, or the
+	// headers given to Stream.UpdateWithHeaders.
+	Header textproto.MIMEHeader
+	// Seq is the sequence number of the frame, starting at 1.
+	Seq uint64
+	// SourceSeq is the sequence number given by the X-Frame-Seq header of the
+	// part, or 0 when there is none. Gaps tell frames dropped upstream.
+	SourceSeq uint64
+	// Time is the time when the frame was received or published.
+	Time time.Time
+	// Skipped is the number of frames dropped before this one in skip-to-latest mode.
+	Skipped int
+
+	codec Codec
+	// contentType is the type of the part of a published frame, "" for JPEG.
+	contentType string
+	// outage marks the frames of WithRelayOutageFrame, which do not make
+	// the stream fresh.
+	outage bool
+	// buf is the pooled buffer of a frame published with UpdateBuffer, it
+	// goes back to bufs when refs drops to 0.
+	buf  *FrameBuffer
+	bufs *sync.Pool
+	refs atomic.Int32
+
+	once sync.Once
+	img  image.Image
+	err  error
+
+	// variants holds the transcoded frames of the viewers, by variantKey.
+	variants sync.Map
+}
+
+// ContentType returns the type of the image, from the part header of a
+// decoded frame. It is image/jpeg when unknown or not a valid header value.
+func (f *Frame) ContentType() string {
+	if f.contentType != "" && validHeaderValue(f.contentType) {
+		return f.contentType
+	}
+	if ct := f.Header.Get("Content-Type"); ct != "" && validHeaderValue(ct) {
+		return ct
+	}
+	return "image/jpeg"
+}
+
+// Image return decoded image of the frame. The frame is decoded at the first
+// call and the result is cached.
+func (f *Frame) Image() (image.Image, error) {
+	f.once.Do(func() {
+		c := f.codec
+		if c == nil {
+			c = DefaultCodec
+		}
+		f.img, f.err = decodeImage(c, f.Data)
+	})
+	return f.img, f.err
+}
+
+// frameSeq parses the X-Frame-Seq header.
+func frameSeq(h textproto.MIMEHeader) uint64 {
+	n, _ := strconv.ParseUint(h.Get("X-Frame-Seq"), 10, 64)
+	return n
+}
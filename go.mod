@@ -1,3 +1,3 @@
 module github.com/WarehouseRobotics/go-mjpeg
 
-go 1.15
+go 1.23
@@ -0,0 +1,85 @@
+package mjpeg
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// WithHealth sets the age of the last frame from which ServeHealth reports
+// the stream as stale. connected, if not nil, tells whether the source is
+// connected, a disconnected source makes the stream unhealthy too.
+func WithHealth(maxAge time.Duration, connected func() bool) StreamOption {
+	return func(s *Stream) {
+		s.healthMaxAge = maxAge
+		s.sourceConnected = connected
+	}
+}
+
+// Health is the state of a stream reported by ServeHealth.
+type Health struct {
+	// Status is "ok", "stale", "disconnected" or "closed".
+	Status string `json:"status"`
+	// LastFrameAge is the time since the last frame, or since the creation
+	// of the stream when there was none, in seconds.
+	LastFrameAge float64 `json:"last_frame_age"`
+	// SourceConnected is only set with the connected func of WithHealth.
+	SourceConnected *bool `json:"source_connected,omitempty"`
+	Clients         int   `json:"clients"`
+	Paused          bool  `json:"paused"`
+}
+
+// Healthy reports whether the status is ok.
+func (h Health) Healthy() bool {
+	return h.Status == "ok"
+}
+
+// Health returns the state of the stream. A paused stream is not stale.
+func (s *Stream) Health() Health {
+	s.m.Lock()
+	age := time.Since(s.updated)
+	closed, paused := s.closed, s.paused
+	s.m.Unlock()
+
+	h := Health{
+		Status:       "ok",
+		LastFrameAge: age.Seconds(),
+		Clients:      s.nclients(),
+		Paused:       paused,
+	}
+	if s.sourceConnected != nil {
+		connected := s.sourceConnected()
+		h.SourceConnected = &connected
+		if !connected {
+			h.Status = "disconnected"
+		}
+	}
+	if s.healthMaxAge > 0 && !paused && age >= s.healthMaxAge && h.Status == "ok" {
+		h.Status = "stale"
+	}
+	if closed {
+		h.Status = "closed"
+	}
+	return h
+}
+
+// ServeHealth writes the Health of the stream as JSON, with the status 503
+// Service Unavailable when it is not healthy, for readiness probes and load
+// balancer checks.
+func (s *Stream) ServeHealth(w http.ResponseWriter, r *http.Request) {
+	h := s.Health()
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+	if !h.Healthy() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	if r.Method == http.MethodHead {
+		return
+	}
+	json.NewEncoder(w).Encode(h)
+}
+
+// HealthHandler returns an http.Handler calling ServeHealth.
+func (s *Stream) HealthHandler() http.Handler {
+	return http.HandlerFunc(s.ServeHealth)
+}
@@ -0,0 +1,300 @@
+// Package hls serves a Stream as HTTP Live Streaming, for the players which
+// do not render multipart MJPEG, such as Safari on iOS. The frames are cut
+// in segments encoded by a pluggable function, e.g. running ffmpeg.
+package hls
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/WarehouseRobotics/go-mjpeg"
+)
+
+// ErrNoSegment is returned by the playlist handler when no segment was
+// encoded in time.
+var ErrNoSegment = errors.New("hls: no segment yet")
+
+// SegmentFrames are the frames of a segment to encode.
+type SegmentFrames struct {
+	Frames []*mjpeg.Frame
+	// Start is the time of the segment from the start of the playlist, the
+	// timestamps of the segment begin there so that they follow the
+	// previous segment.
+	Start    time.Duration
+	Duration time.Duration
+}
+
+// EncodeFunc encodes the frames of a segment, usually to H.264 in MPEG-TS.
+// Each segment must start with a key frame.
+type EncodeFunc func(ctx context.Context, seg *SegmentFrames) ([]byte, error)
+
+// Option is an option of New
+type Option func(*options)
+
+type options struct {
+	duration time.Duration
+	size     int
+	onError  func(error)
+}
+
+// WithSegmentDuration sets the duration of the segments, 2s by default. The
+// latency of the viewers is about three segments.
+func WithSegmentDuration(d time.Duration) Option {
+	return func(o *options) {
+		if d > 0 {
+			o.duration = d
+		}
+	}
+}
+
+// WithPlaylistSize sets the number of segments of the playlist, 5 by default.
+func WithPlaylistSize(n int) Option {
+	return func(o *options) {
+		if n > 0 {
+			o.size = n
+		}
+	}
+}
+
+// WithErrorFunc sets f to be called with the errors of the EncodeFunc. The
+// segment is skipped.
+func WithErrorFunc(f func(error)) Option {
+	return func(o *options) {
+		o.onError = f
+	}
+}
+
+// segment is an encoded segment of the playlist.
+type segment struct {
+	seq      uint64
+	data     []byte
+	duration time.Duration
+}
+
+// Server cuts the frames of a Stream in segments and serves them with a live
+// playlist. Mount it with http.StripPrefix, the playlist is index.m3u8 and
+// the segments are named after their sequence number, e.g. 42.ts.
+type Server struct {
+	s      *mjpeg.Stream
+	encode EncodeFunc
+	opts   options
+
+	m        sync.Mutex
+	segments []segment
+	next     uint64
+	// ready is closed when a segment is added, and then replaced.
+	ready chan struct{}
+}
+
+// New returns a Server of the frames of s, encoded by encode. It encodes
+// nothing before Run.
+func New(s *mjpeg.Stream, encode EncodeFunc, opts ...Option) *Server {
+	h := &Server{
+		s:      s,
+		encode: encode,
+		opts:   options{duration: 2 * time.Second, size: 5},
+		ready:  make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(&h.opts)
+	}
+	return h
+}
+
+// Run cuts the frames in segments and encodes them, until ctx is done or the
+// stream is closed. Frames are dropped while the encoder is late, so that
+// the playlist stays live.
+func (h *Server) Run(ctx context.Context) error {
+	sub, err := h.s.Subscribe(mjpeg.WithSubscriptionBuffer(8))
+	if err != nil {
+		return err
+	}
+	defer sub.Close()
+
+	// The encoder takes the segments in order, one is kept while it is busy.
+	batches := make(chan *SegmentFrames, 1)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for seg := range batches {
+			h.encodeSegment(ctx, seg)
+		}
+	}()
+	defer func() {
+		close(batches)
+		<-done
+	}()
+
+	tick := time.NewTicker(h.opts.duration)
+	defer tick.Stop()
+	var frames []*mjpeg.Frame
+	var start time.Duration
+	for {
+		select {
+		case f, ok := <-sub.C:
+			if !ok {
+				return nil
+			}
+			frames = append(frames, f)
+		case <-tick.C:
+			if len(frames) == 0 {
+				continue
+			}
+			seg := &SegmentFrames{Frames: frames, Start: start, Duration: h.opts.duration}
+			frames = nil
+			select {
+			case batches <- seg:
+				start += seg.Duration
+			default:
+				h.error(fmt.Errorf("hls: encoder late, %d frames dropped", len(seg.Frames)))
+			}
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func (h *Server) encodeSegment(ctx context.Context, seg *SegmentFrames) {
+	data, err := h.encode(ctx, seg)
+	if err != nil {
+		if ctx.Err() == nil {
+			h.error(err)
+		}
+		return
+	}
+	h.m.Lock()
+	defer h.m.Unlock()
+	h.segments = append(h.segments, segment{seq: h.next, data: data, duration: seg.Duration})
+	h.next++
+	// Segments which left the playlist are kept a little for the players
+	// which are still loading them.
+	if n := len(h.segments) - h.opts.size - 2; n > 0 {
+		h.segments = append(h.segments[:0], h.segments[n:]...)
+	}
+	close(h.ready)
+	h.ready = make(chan struct{})
+}
+
+func (h *Server) error(err error) {
+	if h.opts.onError != nil {
+		h.opts.onError(err)
+	}
+}
+
+// ServeHTTP serves the playlist index.m3u8 and the segments.
+func (h *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := path.Base(r.URL.Path)
+	if name == "index.m3u8" {
+		h.servePlaylist(w, r)
+		return
+	}
+	seq, err := strconv.ParseUint(strings.TrimSuffix(name, ".ts"), 10, 64)
+	if err != nil || !strings.HasSuffix(name, ".ts") {
+		http.NotFound(w, r)
+		return
+	}
+	h.serveSegment(w, r, seq)
+}
+
+// servePlaylist answers once there is a segment, players give up on an
+// empty playlist.
+func (h *Server) servePlaylist(w http.ResponseWriter, r *http.Request) {
+	timeout := time.NewTimer(3 * h.opts.duration)
+	defer timeout.Stop()
+	for {
+		h.m.Lock()
+		segs := h.segments[max(0, len(h.segments)-h.opts.size):]
+		ready := h.ready
+		var b bytes.Buffer
+		if len(segs) > 0 {
+			h.playlist(&b, segs)
+		}
+		h.m.Unlock()
+		if b.Len() > 0 {
+			w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+			w.Header().Set("Cache-Control", "no-cache")
+			w.Write(b.Bytes())
+			return
+		}
+		select {
+		case <-ready:
+		case <-timeout.C:
+			w.Header().Set("Retry-After", strconv.Itoa(int(h.opts.duration.Seconds())+1))
+			http.Error(w, ErrNoSegment.Error(), http.StatusServiceUnavailable)
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// playlist writes the live playlist of segs, called with h.m held.
+func (h *Server) playlist(b *bytes.Buffer, segs []segment) {
+	target := 0.0
+	for _, s := range segs {
+		target = max(target, math.Ceil(s.duration.Seconds()))
+	}
+	fmt.Fprintf(b, "#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:%d\n#EXT-X-MEDIA-SEQUENCE:%d\n", int(target), segs[0].seq)
+	for _, s := range segs {
+		fmt.Fprintf(b, "#EXTINF:%.3f,\n%d.ts\n", s.duration.Seconds(), s.seq)
+	}
+}
+
+func (h *Server) serveSegment(w http.ResponseWriter, r *http.Request, seq uint64) {
+	h.m.Lock()
+	var data []byte
+	for _, s := range h.segments {
+		if s.seq == seq {
+			data = s.data
+		}
+	}
+	h.m.Unlock()
+	if data == nil {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "video/mp2t")
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	// A segment never changes once in the playlist.
+	w.Header().Set("Cache-Control", "max-age=60")
+	w.Write(data)
+}
+
+// FFmpeg returns an EncodeFunc running ffmpeg, found in PATH, for each
+// segment to encode it to H.264 in MPEG-TS. args replace the encoding
+// options, "-c:v libx264 -preset veryfast -tune zerolatency -pix_fmt
+// yuv420p" by default.
+func FFmpeg(args ...string) EncodeFunc {
+	if len(args) == 0 {
+		args = []string{"-c:v", "libx264", "-preset", "veryfast", "-tune", "zerolatency", "-pix_fmt", "yuv420p"}
+	}
+	return func(ctx context.Context, seg *SegmentFrames) ([]byte, error) {
+		// The frames are spread evenly over the segment.
+		fps := float64(len(seg.Frames)) / seg.Duration.Seconds()
+		cmdArgs := []string{"-hide_banner", "-loglevel", "error",
+			"-f", "mjpeg", "-framerate", strconv.FormatFloat(fps, 'f', 3, 64), "-i", "pipe:0"}
+		cmdArgs = append(cmdArgs, args...)
+		cmdArgs = append(cmdArgs, "-output_ts_offset", strconv.FormatFloat(seg.Start.Seconds(), 'f', 3, 64), "-f", "mpegts", "pipe:1")
+		cmd := exec.CommandContext(ctx, "ffmpeg", cmdArgs...)
+		var in bytes.Buffer
+		for _, f := range seg.Frames {
+			in.Write(f.Data)
+		}
+		var out, stderr bytes.Buffer
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = &in, &out, &stderr
+		if err := cmd.Run(); err != nil {
+			return nil, fmt.Errorf("hls: ffmpeg: %w: %s", err, bytes.TrimSpace(stderr.Bytes()))
+		}
+		return out.Bytes(), nil
+	}
+}
@@ -0,0 +1,202 @@
+package mjpeg
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Hub serves named streams, e.g. one per camera:
+//
+//	GET /streams                 JSON index of the streams
+//	GET /streams/{name}          the stream, like Stream.ServeHTTP
+//	GET /streams/{name}/snapshot the last frame, like Stream.ServeSnapshot
+//	GET /streams/{name}/health   the health, like Stream.ServeHealth
+//	GET /streams/{name}/ws       the stream, like Stream.ServeWebSocket
+//	GET /streams/{name}/events   the stream, like Stream.ServeEvents
+//	GET /streams/{name}/mp4      the stream, like Stream.ServeMP4
+type Hub struct {
+	opts    []StreamOption
+	m       sync.Mutex
+	streams map[string]*Stream
+	relays  map[string]*hubRelay
+	mux     *http.ServeMux
+}
+
+// hubRelay is a camera relayed by AddCamera.
+type hubRelay struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// HubEntry describes a stream of the index of a Hub.
+type HubEntry struct {
+	Name     string `json:"name"`
+	URL      string `json:"url"`
+	Snapshot string `json:"snapshot"`
+	Clients  int    `json:"clients"`
+}
+
+// NewHub return new instance of Hub without streams. opts apply to the
+// streams made by AddCamera.
+func NewHub(opts ...StreamOption) *Hub {
+	h := &Hub{
+		opts:    opts,
+		streams: make(map[string]*Stream),
+		relays:  make(map[string]*hubRelay),
+	}
+	h.mux = http.NewServeMux()
+	h.mux.HandleFunc("/streams", h.serveIndex)
+	h.mux.HandleFunc("/streams/{name}", h.serve((*Stream).ServeHTTP))
+	h.mux.HandleFunc("/streams/{name}/snapshot", h.serve((*Stream).ServeSnapshot))
+	h.mux.HandleFunc("/streams/{name}/health", h.serve((*Stream).ServeHealth))
+	h.mux.HandleFunc("/streams/{name}/ws", h.serve((*Stream).ServeWebSocket))
+	h.mux.HandleFunc("/streams/{name}/events", h.serve((*Stream).ServeEvents))
+	h.mux.HandleFunc("/streams/{name}/mp4", h.serve((*Stream).ServeMP4))
+	return h
+}
+
+// Add makes s available as name, while serving. The name must not be empty
+// nor contain a slash.
+func (h *Hub) Add(name string, s *Stream) error {
+	h.m.Lock()
+	defer h.m.Unlock()
+	return h.add(name, s)
+}
+
+// add is called with h.m held.
+func (h *Hub) add(name string, s *Stream) error {
+	if name == "" || strings.Contains(name, "/") {
+		return errors.New("mjpeg: invalid stream name " + name)
+	}
+	if _, ok := h.streams[name]; ok {
+		return errors.New("mjpeg: stream " + name + " already in hub")
+	}
+	h.streams[name] = s
+	return nil
+}
+
+// AddCamera relays the camera at URL u to a new stream name, with a
+// supervised relay which connects again when the camera fails. The relay
+// runs until the stream is removed.
+func (h *Hub) AddCamera(name, u string, opts ...RelayOption) (*Relay, error) {
+	s := NewStream(h.opts...)
+	r, err := NewSupervisedRelay(u, s, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	h.m.Lock()
+	defer h.m.Unlock()
+	if err := h.add(name, s); err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	hr := &hubRelay{cancel: cancel, done: make(chan struct{})}
+	h.relays[name] = hr
+	go func() {
+		defer close(hr.done)
+		r.Run(ctx)
+	}()
+	return r, nil
+}
+
+// Remove stops serving the stream name, and its camera for AddCamera. The
+// viewers get the end of the stream, as with Stream.Shutdown, which is
+// waited for until ctx is done.
+func (h *Hub) Remove(ctx context.Context, name string) error {
+	h.m.Lock()
+	s, ok := h.streams[name]
+	hr := h.relays[name]
+	delete(h.streams, name)
+	delete(h.relays, name)
+	h.m.Unlock()
+	if !ok {
+		return errors.New("mjpeg: stream " + name + " not in hub")
+	}
+	if hr != nil {
+		hr.cancel()
+		<-hr.done
+	}
+	return s.Shutdown(ctx)
+}
+
+// Stream return the stream name.
+func (h *Hub) Stream(name string) (*Stream, bool) {
+	h.m.Lock()
+	defer h.m.Unlock()
+	s, ok := h.streams[name]
+	return s, ok
+}
+
+// Names return the names of the streams, sorted.
+func (h *Hub) Names() []string {
+	h.m.Lock()
+	defer h.m.Unlock()
+	names := make([]string, 0, len(h.streams))
+	for name := range h.streams {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Close stops the cameras and closes all streams.
+func (h *Hub) Close() error {
+	// The streams leave the hub under the lock and are closed after, so
+	// that the handlers and Add are not held up by the cameras stopping.
+	h.m.Lock()
+	streams, relays := h.streams, h.relays
+	h.streams = make(map[string]*Stream)
+	h.relays = make(map[string]*hubRelay)
+	h.m.Unlock()
+	for _, hr := range relays {
+		hr.cancel()
+	}
+	for _, hr := range relays {
+		<-hr.done
+	}
+	for _, s := range streams {
+		s.Close()
+	}
+	return nil
+}
+
+func (h *Hub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mux.ServeHTTP(w, r)
+}
+
+func (h *Hub) serve(f func(*Stream, http.ResponseWriter, *http.Request)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s, ok := h.Stream(r.PathValue("name"))
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		f(s, w, r)
+	}
+}
+
+func (h *Hub) serveIndex(w http.ResponseWriter, r *http.Request) {
+	entries := []HubEntry{}
+	for _, name := range h.Names() {
+		s, ok := h.Stream(name)
+		if !ok {
+			continue
+		}
+		entries = append(entries, HubEntry{
+			Name:     name,
+			URL:      "/streams/" + url.PathEscape(name),
+			Snapshot: "/streams/" + url.PathEscape(name) + "/snapshot",
+			Clients:  s.nclients(),
+		})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+	json.NewEncoder(w).Encode(entries)
+}
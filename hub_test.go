@@ -0,0 +1,21 @@
+package mjpeg
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestHubClose(t *testing.T) {
+	h := NewHub()
+	s := NewStream()
+	if err := h.Add("front", s); err != nil {
+		t.Fatal(err)
+	}
+	h.Close()
+	if _, ok := h.Stream("front"); ok {
+		t.Error("stream still in the hub")
+	}
+	if err := s.Update(gradientJPEG(t, 8, 8)); !errors.Is(err, ErrStreamClosed) {
+		t.Errorf("Update after Close: %v", err)
+	}
+}
@@ -0,0 +1,14 @@
+// Package timescale converts durations to the ticks of media clocks, such as
+// the 90 kHz clock of MP4 and RTP video.
+package timescale
+
+import "time"
+
+// Ticks returns d in ticks of a clock of rate Hz. Unlike d*rate/time.Second,
+// it does not overflow after 57 hours at 90 kHz. A negative d is 0.
+func Ticks(d time.Duration, rate uint64) uint64 {
+	if d < 0 {
+		return 0
+	}
+	return uint64(d/time.Second)*rate + uint64(d%time.Second)*rate/uint64(time.Second)
+}
@@ -0,0 +1,28 @@
+package timescale
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTicks(t *testing.T) {
+	tests := []struct {
+		d    time.Duration
+		rate uint64
+		want uint64
+	}{
+		{0, 90000, 0},
+		{-time.Second, 90000, 0},
+		{time.Second, 90000, 90000},
+		{40 * time.Millisecond, 90000, 3600},
+		{1500 * time.Millisecond, 1000, 1500},
+		// d*90000 overflows uint64 past 57 hours.
+		{60 * time.Hour, 90000, 60 * 3600 * 90000},
+		{1000*time.Hour + 20*time.Millisecond, 90000, 1000*3600*90000 + 1800},
+	}
+	for _, tt := range tests {
+		if got := Ticks(tt.d, tt.rate); got != tt.want {
+			t.Errorf("Ticks(%v, %d) = %d, want %d", tt.d, tt.rate, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,669 @@
+package mjpeg
+
+import (
+	"encoding/binary"
+	"errors"
+	"math/bits"
+)
+
+// errNotLossless is returned by transformJPEG for the frames it can not
+// transform without decoding them.
+var errNotLossless = errors.New("mjpeg: frame can not be transformed losslessly")
+
+// zigzag maps the zig-zag order of the coefficients of a block to their
+// natural order.
+var zigzag = [64]int{
+	0, 1, 8, 16, 9, 2, 3, 10, 17, 24, 32, 25, 18, 11, 4, 5,
+	12, 19, 26, 33, 40, 48, 41, 34, 27, 20, 13, 6, 7, 14, 21, 28,
+	35, 42, 49, 56, 57, 50, 43, 36, 29, 22, 15, 23, 30, 37, 44, 51,
+	58, 59, 52, 45, 38, 31, 39, 46, 53, 60, 61, 54, 47, 55, 62, 63,
+}
+
+// huffTable is a Huffman table of a DHT segment, for decoding and encoding.
+type huffTable struct {
+	maxcode [18]int32
+	mincode [17]int32
+	valptr  [17]int32
+	vals    []byte
+	// code and size are the code of each symbol, size 0 when the table
+	// has none.
+	code [256]uint16
+	size [256]byte
+	// lookup has the size and the symbol of the codes of up to lookupBits
+	// bits, by the next lookupBits bits.
+	lookup [1 << lookupBits]uint16
+}
+
+const lookupBits = 9
+
+// newHuffTable returns the table of a DHT segment, nil when its codes do not
+// fit in their sizes.
+func newHuffTable(counts []byte, vals []byte) *huffTable {
+	t := &huffTable{vals: vals}
+	code, k := int32(0), int32(0)
+	for l := 1; l <= 16; l++ {
+		n := int32(counts[l-1])
+		t.valptr[l], t.mincode[l] = k, code
+		if code+n > 1<<l {
+			return nil
+		}
+		for i := int32(0); i < n; i++ {
+			t.code[vals[k]], t.size[vals[k]] = uint16(code), byte(l)
+			if l <= lookupBits {
+				first := code << (lookupBits - l)
+				for j := int32(0); j < 1<<(lookupBits-l); j++ {
+					t.lookup[first+j] = uint16(l)<<8 | uint16(vals[k])
+				}
+			}
+			code++
+			k++
+		}
+		t.maxcode[l] = code - 1
+		if n == 0 {
+			t.maxcode[l] = -1
+		}
+		code <<= 1
+	}
+	return t
+}
+
+// jpegComponent is a component of a frame with its DCT coefficients, 64 per
+// block in natural order.
+type jpegComponent struct {
+	id, h, v, tq byte
+	td, ta       byte
+	bw, bh       int
+	coef         []int32
+}
+
+// jpegSegment is a marker segment preceding the scan.
+type jpegSegment struct {
+	marker byte
+	data   []byte
+}
+
+// coefImage is a baseline JPEG decoded to its DCT coefficients.
+type coefImage struct {
+	width, height int
+	comps         []*jpegComponent
+	quant         [4][64]uint16
+	quant16       [4]bool
+	dc, ac        [4]*huffTable
+	restart       int
+	segments      []jpegSegment
+	sos           []byte
+	// scan is the entropy-coded data, up to the end of the frame.
+	scan []byte
+}
+
+// transformJPEG returns b rotated or flipped by o without decoding the
+// pixels, by moving the DCT coefficients, like jpegtran does. It only does
+// baseline JPEG with one scan, whose size is a multiple of the MCU along the
+// flipped edges, and fails with errNotLossless otherwise.
+func transformJPEG(b []byte, o Orientation) ([]byte, error) {
+	img, err := parseCoefImage(b)
+	if err != nil {
+		return nil, err
+	}
+	if err := img.decode(); err != nil {
+		return nil, err
+	}
+	transpose := o == Rotate90 || o == Rotate270
+	flipX := o == FlipHorizontal || o == Rotate180 || o == Rotate90
+	flipY := o == FlipVertical || o == Rotate180 || o == Rotate270
+	if transpose {
+		img.transpose()
+	}
+	mcuW, mcuH := 8, 8
+	if len(img.comps) > 1 {
+		for _, c := range img.comps {
+			mcuW, mcuH = max(mcuW, 8*int(c.h)), max(mcuH, 8*int(c.v))
+		}
+	}
+	if flipX && img.width%mcuW != 0 || flipY && img.height%mcuH != 0 {
+		return nil, errNotLossless
+	}
+	img.flip(flipX, flipY)
+	return img.encode()
+}
+
+func parseCoefImage(b []byte) (*coefImage, error) {
+	if !isJPEG(b) {
+		return nil, errNotLossless
+	}
+	img := &coefImage{}
+	i := 2
+	for {
+		if i >= len(b) || b[i] != 0xFF {
+			return nil, errNotLossless
+		}
+		for i < len(b) && b[i] == 0xFF {
+			i++
+		}
+		if i >= len(b) {
+			return nil, errNotLossless
+		}
+		marker := b[i]
+		i++
+		if marker >= 0xD0 && marker <= 0xD7 || marker == 0x01 {
+			continue
+		}
+		if i+2 > len(b) {
+			return nil, errNotLossless
+		}
+		n := int(binary.BigEndian.Uint16(b[i:]))
+		if n < 2 || i+n > len(b) {
+			return nil, errNotLossless
+		}
+		data := b[i+2 : i+n]
+		i += n
+		var err error
+		switch {
+		case marker == 0xC0 || marker == 0xC1:
+			err = img.parseSOF(data)
+		case marker == 0xC4:
+			err = img.parseDHT(data)
+		case marker == 0xDB:
+			err = img.parseDQT(data)
+		case marker == 0xDD:
+			if len(data) < 2 {
+				return nil, errNotLossless
+			}
+			img.restart = int(binary.BigEndian.Uint16(data))
+		case marker == 0xDA:
+			img.sos = data
+			img.scan = b[i:]
+			return img, img.parseSOS(data)
+		case marker >= 0xC2 && marker <= 0xCF, marker == 0xD9:
+			// Progressive, lossless, arithmetic coding or no scan.
+			return nil, errNotLossless
+		}
+		if err != nil {
+			return nil, err
+		}
+		img.segments = append(img.segments, jpegSegment{marker, data})
+	}
+}
+
+func (img *coefImage) parseSOF(d []byte) error {
+	if len(d) < 6 || d[0] != 8 || img.comps != nil {
+		return errNotLossless
+	}
+	img.height = int(binary.BigEndian.Uint16(d[1:]))
+	img.width = int(binary.BigEndian.Uint16(d[3:]))
+	n := int(d[5])
+	// A scan has up to 4 components, with up to 10 blocks per MCU when
+	// interleaved.
+	if img.width == 0 || img.height == 0 || n == 0 || n > 4 || len(d) < 6+3*n {
+		return errNotLossless
+	}
+	blocks := 0
+	for i := 0; i < n; i++ {
+		c := d[6+3*i:]
+		comp := &jpegComponent{id: c[0], h: c[1] >> 4, v: c[1] & 15, tq: c[2]}
+		if comp.h < 1 || comp.h > 4 || comp.v < 1 || comp.v > 4 || comp.tq > 3 {
+			return errNotLossless
+		}
+		blocks += int(comp.h) * int(comp.v)
+		img.comps = append(img.comps, comp)
+	}
+	if n > 1 && blocks > 10 {
+		return errNotLossless
+	}
+	return nil
+}
+
+func (img *coefImage) parseDHT(d []byte) error {
+	for len(d) > 0 {
+		if len(d) < 17 || d[0]>>4 > 1 || d[0]&15 > 3 {
+			return errNotLossless
+		}
+		n := 0
+		for _, c := range d[1:17] {
+			n += int(c)
+		}
+		if n > 256 || len(d) < 17+n {
+			return errNotLossless
+		}
+		t := newHuffTable(d[1:17], d[17:17+n])
+		if t == nil {
+			return errNotLossless
+		}
+		if d[0]>>4 == 0 {
+			img.dc[d[0]&15] = t
+		} else {
+			img.ac[d[0]&15] = t
+		}
+		d = d[17+n:]
+	}
+	return nil
+}
+
+func (img *coefImage) parseDQT(d []byte) error {
+	for len(d) > 0 {
+		pq, tq := d[0]>>4, d[0]&15
+		n := 64 * (1 + int(pq))
+		if pq > 1 || tq > 3 || len(d) < 1+n {
+			return errNotLossless
+		}
+		img.quant16[tq] = pq == 1
+		for k := 0; k < 64; k++ {
+			q := uint16(d[1+k])
+			if pq == 1 {
+				q = binary.BigEndian.Uint16(d[1+2*k:])
+			}
+			img.quant[tq][zigzag[k]] = q
+		}
+		d = d[1+n:]
+	}
+	return nil
+}
+
+func (img *coefImage) parseSOS(d []byte) error {
+	if len(d) < 1 || img.comps == nil {
+		return errNotLossless
+	}
+	n := int(d[0])
+	// A single scan has every component.
+	if n != len(img.comps) || len(d) < 4+2*n {
+		return errNotLossless
+	}
+	for i := 0; i < n; i++ {
+		c := img.comps[i]
+		if d[1+2*i] != c.id {
+			return errNotLossless
+		}
+		c.td, c.ta = d[2+2*i]>>4, d[2+2*i]&15
+		if c.td > 3 || c.ta > 3 || img.dc[c.td] == nil || img.ac[c.ta] == nil {
+			return errNotLossless
+		}
+	}
+	if s := d[1+2*n:]; s[0] != 0 || s[1] != 63 || s[2] != 0 {
+		return errNotLossless
+	}
+	return nil
+}
+
+// mcus returns the number of MCUs of the scan, horizontally and vertically,
+// and sets the block grid of the components.
+func (img *coefImage) mcus() (int, int) {
+	var hmax, vmax int
+	for _, c := range img.comps {
+		hmax, vmax = max(hmax, int(c.h)), max(vmax, int(c.v))
+	}
+	if len(img.comps) == 1 {
+		// A non-interleaved scan has a block per MCU, without padding.
+		c := img.comps[0]
+		c.bw = (img.width*int(c.h)/hmax + 7) / 8
+		c.bh = (img.height*int(c.v)/vmax + 7) / 8
+		return c.bw, c.bh
+	}
+	mx := (img.width + 8*hmax - 1) / (8 * hmax)
+	my := (img.height + 8*vmax - 1) / (8 * vmax)
+	for _, c := range img.comps {
+		c.bw, c.bh = mx*int(c.h), my*int(c.v)
+	}
+	return mx, my
+}
+
+// blocks calls fn for each block of an MCU, in the order of the scan.
+func (img *coefImage) blocks(mx, mcu int, fn func(c *jpegComponent, ci int, blk []int32) error) error {
+	x, y := mcu%mx, mcu/mx
+	if len(img.comps) == 1 {
+		c := img.comps[0]
+		i := y*c.bw + x
+		return fn(c, 0, c.coef[64*i:64*i+64])
+	}
+	for ci, c := range img.comps {
+		for by := 0; by < int(c.v); by++ {
+			for bx := 0; bx < int(c.h); bx++ {
+				i := (y*int(c.v)+by)*c.bw + x*int(c.h) + bx
+				if err := fn(c, ci, c.coef[64*i:64*i+64]); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (img *coefImage) decode() error {
+	mx, my := img.mcus()
+	n := 0
+	for _, c := range img.comps {
+		n += c.bw * c.bh
+	}
+	// A block takes 2 bits at least, a larger size is a corrupted frame.
+	if n > 4*len(img.scan) {
+		return errNotLossless
+	}
+	for _, c := range img.comps {
+		c.coef = make([]int32, 64*c.bw*c.bh)
+	}
+	r := &bitReader{b: img.scan}
+	var pred [4]int32
+	for m := 0; m < mx*my; m++ {
+		if img.restart > 0 && m > 0 && m%img.restart == 0 {
+			if err := r.restart(); err != nil {
+				return err
+			}
+			pred = [4]int32{}
+		}
+		err := img.blocks(mx, m, func(c *jpegComponent, ci int, blk []int32) error {
+			return r.block(blk, &pred[ci], img.dc[c.td], img.ac[c.ta])
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// transpose swaps the rows and the columns of the image.
+func (img *coefImage) transpose() {
+	img.width, img.height = img.height, img.width
+	for _, c := range img.comps {
+		coef := make([]int32, len(c.coef))
+		for by := 0; by < c.bh; by++ {
+			for bx := 0; bx < c.bw; bx++ {
+				src := c.coef[64*(by*c.bw+bx):]
+				dst := coef[64*(bx*c.bh+by):]
+				for k := 0; k < 64; k++ {
+					dst[k] = src[k%8*8+k/8]
+				}
+			}
+		}
+		c.coef, c.bw, c.bh, c.h, c.v = coef, c.bh, c.bw, c.v, c.h
+	}
+	for i := range img.quant {
+		q := img.quant[i]
+		for k := 0; k < 64; k++ {
+			img.quant[i][k] = q[k%8*8+k/8]
+		}
+	}
+}
+
+// flip mirrors the image horizontally and or vertically. Mirroring a block
+// negates its odd coefficients along the axis.
+func (img *coefImage) flip(x, y bool) {
+	if !x && !y {
+		return
+	}
+	var sign [64]int32
+	for k := range sign {
+		sign[k] = 1
+		if x && k%2 == 1 {
+			sign[k] = -sign[k]
+		}
+		if y && k/8%2 == 1 {
+			sign[k] = -sign[k]
+		}
+	}
+	for _, c := range img.comps {
+		coef := make([]int32, len(c.coef))
+		for by := 0; by < c.bh; by++ {
+			for bx := 0; bx < c.bw; bx++ {
+				tx, ty := bx, by
+				if x {
+					tx = c.bw - 1 - bx
+				}
+				if y {
+					ty = c.bh - 1 - by
+				}
+				src := c.coef[64*(by*c.bw+bx):]
+				dst := coef[64*(ty*c.bw+tx):]
+				for k := 0; k < 64; k++ {
+					dst[k] = src[k] * sign[k]
+				}
+			}
+		}
+		c.coef = coef
+	}
+}
+
+// encode returns the image as JPEG, with the segments of the original one
+// and its Huffman tables, without restart markers.
+func (img *coefImage) encode() ([]byte, error) {
+	w := &bitWriter{b: make([]byte, 0, len(img.scan)+1024)}
+	w.b = append(w.b, 0xFF, 0xD8)
+	for _, s := range img.segments {
+		switch s.marker {
+		case 0xC0, 0xC1:
+			w.segment(s.marker, img.sof(s.data))
+		case 0xDB:
+			w.segment(s.marker, img.dqt(s.data))
+		case 0xDD:
+		default:
+			w.segment(s.marker, s.data)
+		}
+	}
+	w.segment(0xDA, img.sos)
+
+	mx, my := img.mcus()
+	var pred [4]int32
+	for m := 0; m < mx*my; m++ {
+		err := img.blocks(mx, m, func(c *jpegComponent, ci int, blk []int32) error {
+			return w.block(blk, &pred[ci], img.dc[c.td], img.ac[c.ta])
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	w.flush()
+	return append(w.b, 0xFF, 0xD9), nil
+}
+
+// sof returns the SOF segment d with the size and the sampling of img.
+func (img *coefImage) sof(d []byte) []byte {
+	d = append([]byte(nil), d...)
+	binary.BigEndian.PutUint16(d[1:], uint16(img.height))
+	binary.BigEndian.PutUint16(d[3:], uint16(img.width))
+	for i, c := range img.comps {
+		d[7+3*i] = c.h<<4 | c.v
+	}
+	return d
+}
+
+// dqt returns the DQT segment d with the tables of img.
+func (img *coefImage) dqt(d []byte) []byte {
+	d = append([]byte(nil), d...)
+	for s := d; len(s) > 0; {
+		tq := s[0] & 3
+		for k := 0; k < 64; k++ {
+			q := img.quant[tq][zigzag[k]]
+			if img.quant16[tq] {
+				binary.BigEndian.PutUint16(s[1+2*k:], q)
+			} else {
+				s[1+k] = byte(q)
+			}
+		}
+		s = s[1+64*(1+int(s[0]>>4)):]
+	}
+	return d
+}
+
+// bitReader reads the entropy-coded data of a scan.
+type bitReader struct {
+	b   []byte
+	i   int
+	acc uint64
+	n   uint
+	// marker is set at the end of the data, zeros are read after it.
+	marker bool
+}
+
+// fill reads bytes in r.acc until it has more than 48 bits.
+func (r *bitReader) fill() {
+	for r.n <= 48 {
+		var c byte
+		switch {
+		case r.marker || r.i >= len(r.b):
+		case r.b[r.i] == 0xFF && r.i+1 < len(r.b) && r.b[r.i+1] == 0:
+			c = 0xFF
+			r.i += 2
+		case r.b[r.i] == 0xFF:
+			r.marker = true
+		default:
+			c = r.b[r.i]
+			r.i++
+		}
+		r.acc = r.acc<<8 | uint64(c)
+		r.n += 8
+	}
+}
+
+func (r *bitReader) bits(n byte) int32 {
+	if n == 0 {
+		return 0
+	}
+	r.fill()
+	r.n -= uint(n)
+	return int32(r.acc>>r.n) & (1<<n - 1)
+}
+
+func (r *bitReader) decode(t *huffTable) (byte, error) {
+	r.fill()
+	if e := t.lookup[r.acc>>(r.n-lookupBits)&(1<<lookupBits-1)]; e != 0 {
+		r.n -= uint(e >> 8)
+		return byte(e), nil
+	}
+	for l := lookupBits + 1; l <= 16; l++ {
+		code := int32(r.acc>>(r.n-uint(l))) & (1<<l - 1)
+		if code <= t.maxcode[l] {
+			r.n -= uint(l)
+			return t.vals[t.valptr[l]+code-t.mincode[l]], nil
+		}
+	}
+	return 0, errNotLossless
+}
+
+// restart skips the RSTn marker ending a restart interval.
+func (r *bitReader) restart() error {
+	r.n, r.marker = 0, false
+	if r.i+1 < len(r.b) && r.b[r.i] == 0xFF && r.b[r.i+1]&0xF8 == 0xD0 {
+		r.i += 2
+		return nil
+	}
+	return errNotLossless
+}
+
+func (r *bitReader) block(blk []int32, pred *int32, dc, ac *huffTable) error {
+	s, err := r.decode(dc)
+	if err != nil || s > 11 {
+		return errNotLossless
+	}
+	*pred += extend(r.bits(s), s)
+	blk[0] = *pred
+	for k := 1; k < 64; {
+		rs, err := r.decode(ac)
+		if err != nil {
+			return err
+		}
+		run, size := int(rs>>4), rs&15
+		if size == 0 {
+			if run != 15 {
+				break
+			}
+			k += 16
+			continue
+		}
+		k += run
+		if k > 63 {
+			return errNotLossless
+		}
+		blk[zigzag[k]] = extend(r.bits(size), size)
+		k++
+	}
+	return nil
+}
+
+// extend returns the coefficient of the s bits v.
+func extend(v int32, s byte) int32 {
+	if s > 0 && v < 1<<(s-1) {
+		return v - 1<<s + 1
+	}
+	return v
+}
+
+// bitWriter writes a JPEG, stuffing the entropy-coded data.
+type bitWriter struct {
+	b   []byte
+	acc uint32
+	n   uint
+}
+
+func (w *bitWriter) segment(marker byte, data []byte) {
+	w.b = append(w.b, 0xFF, marker, byte((len(data)+2)>>8), byte(len(data)+2))
+	w.b = append(w.b, data...)
+}
+
+func (w *bitWriter) write(v uint32, n uint) {
+	w.acc = w.acc<<n | v&(1<<n-1)
+	w.n += n
+	for w.n >= 8 {
+		c := byte(w.acc >> (w.n - 8))
+		w.b = append(w.b, c)
+		if c == 0xFF {
+			w.b = append(w.b, 0)
+		}
+		w.n -= 8
+	}
+}
+
+// flush pads the last byte with ones.
+func (w *bitWriter) flush() {
+	if w.n > 0 {
+		w.write(1<<(8-w.n)-1, 8-w.n)
+	}
+}
+
+func (w *bitWriter) huff(t *huffTable, sym byte) error {
+	if t.size[sym] == 0 {
+		// The table was made for the symbols of the original image.
+		return errNotLossless
+	}
+	w.write(uint32(t.code[sym]), uint(t.size[sym]))
+	return nil
+}
+
+func (w *bitWriter) block(blk []int32, pred *int32, dc, ac *huffTable) error {
+	s, v := category(blk[0] - *pred)
+	*pred = blk[0]
+	if err := w.huff(dc, s); err != nil {
+		return err
+	}
+	w.write(v, uint(s))
+	run := 0
+	for k := 1; k < 64; k++ {
+		c := blk[zigzag[k]]
+		if c == 0 {
+			run++
+			continue
+		}
+		for ; run > 15; run -= 16 {
+			if err := w.huff(ac, 0xF0); err != nil {
+				return err
+			}
+		}
+		s, v := category(c)
+		if err := w.huff(ac, byte(run<<4)|s); err != nil {
+			return err
+		}
+		w.write(v, uint(s))
+		run = 0
+	}
+	if run > 0 {
+		return w.huff(ac, 0x00)
+	}
+	return nil
+}
+
+// category returns the size of c and its bits.
+func category(c int32) (byte, uint32) {
+	a := c
+	if a < 0 {
+		a, c = -a, c-1
+	}
+	s := bits.Len32(uint32(a))
+	return byte(s), uint32(c) & (1<<s - 1)
+}
@@ -0,0 +1,143 @@
+package mjpeg
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+)
+
+// gradientJPEG returns a w x h 4:2:0 JPEG of a gradient.
+func gradientJPEG(t testing.TB, w, h int) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.RGBA{uint8(4 * x), uint8(4 * y), uint8(2 * (x + y)), 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 90}); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// replaceSegment returns b with the data of its first segment of marker
+// replaced by data.
+func replaceSegment(t testing.TB, b []byte, marker byte, data []byte) []byte {
+	t.Helper()
+	for i := 2; i+4 <= len(b); {
+		n := int(binary.BigEndian.Uint16(b[i+2:]))
+		if b[i+1] == marker {
+			out := append([]byte{}, b[:i+2]...)
+			out = binary.BigEndian.AppendUint16(out, uint16(len(data)+2))
+			out = append(out, data...)
+			return append(out, b[i+2+n:]...)
+		}
+		i += 2 + n
+	}
+	t.Fatalf("no segment %X", marker)
+	return nil
+}
+
+// withComponents returns b with a SOF and a SOS of the components of
+// sampling hv, sharing the tables of the first component.
+func withComponents(t testing.TB, b []byte, hv ...byte) []byte {
+	sof := []byte{8, 0, 16, 0, 16, byte(len(hv))}
+	sos := []byte{byte(len(hv))}
+	for i, s := range hv {
+		sof = append(sof, byte(i+1), s, 0)
+		sos = append(sos, byte(i+1), 0x00)
+	}
+	sos = append(sos, 0, 63, 0)
+	b = replaceSegment(t, b, 0xC0, sof)
+	return replaceSegment(t, b, 0xDA, sos)
+}
+
+func maxPixelDiff(a, b image.Image) int {
+	if a.Bounds().Size() != b.Bounds().Size() {
+		return 1 << 16
+	}
+	d := 0
+	ra, rb := a.Bounds(), b.Bounds()
+	for y := 0; y < ra.Dy(); y++ {
+		for x := 0; x < ra.Dx(); x++ {
+			r1, g1, b1, _ := a.At(ra.Min.X+x, ra.Min.Y+y).RGBA()
+			r2, g2, b2, _ := b.At(rb.Min.X+x, rb.Min.Y+y).RGBA()
+			for _, v := range []int{int(r1>>8) - int(r2>>8), int(g1>>8) - int(g2>>8), int(b1>>8) - int(b2>>8)} {
+				d = max(d, v, -v)
+			}
+		}
+	}
+	return d
+}
+
+func TestTransformJPEG(t *testing.T) {
+	src := gradientJPEG(t, 64, 48)
+	want, err := jpeg.Decode(bytes.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, o := range []Orientation{Rotate90, Rotate180, Rotate270, FlipHorizontal, FlipVertical} {
+		b, err := transformJPEG(src, o)
+		if err != nil {
+			t.Fatalf("orientation %d: %v", o, err)
+		}
+		got, err := jpeg.Decode(bytes.NewReader(b))
+		if err != nil {
+			t.Fatalf("orientation %d: decode: %v", o, err)
+		}
+		// The pixel path rounds the colors again.
+		if d := maxPixelDiff(oriented(want, o), got); d > 3 {
+			t.Errorf("orientation %d: pixels differ by %d", o, d)
+		}
+	}
+}
+
+func TestTransformJPEGErrors(t *testing.T) {
+	src := gradientJPEG(t, 64, 48)
+	tests := []struct {
+		name string
+		b    []byte
+		o    Orientation
+	}{
+		{"not JPEG", []byte("not a jpeg"), Rotate90},
+		{"truncated", src[:len(src)/2], Rotate90},
+		{"headers only", src[:200], Rotate180},
+		{"not MCU multiple", gradientJPEG(t, 60, 44), FlipHorizontal},
+		{"progressive", bytes.Replace(src, []byte{0xFF, 0xC0}, []byte{0xFF, 0xC2}, 1), Rotate90},
+		{"5 components", withComponents(t, src, 0x11, 0x11, 0x11, 0x11, 0x11), Rotate90},
+		{"12 blocks per MCU", withComponents(t, src, 0x22, 0x22, 0x22), Rotate90},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := transformJPEG(tt.b, tt.o); !errors.Is(err, errNotLossless) {
+				t.Errorf("err = %v, want errNotLossless", err)
+			}
+		})
+	}
+}
+
+func FuzzTransformJPEG(f *testing.F) {
+	src := gradientJPEG(f, 32, 32)
+	f.Add(src, uint8(Rotate90))
+	f.Add(withComponents(f, src, 0x11, 0x11, 0x11, 0x11, 0x11), uint8(Rotate180))
+	f.Add(withComponents(f, src, 0x22, 0x22, 0x22), uint8(FlipVertical))
+	f.Fuzz(func(t *testing.T, b []byte, o uint8) {
+		out, err := transformJPEG(b, Orientation(o%5+1))
+		if err != nil {
+			return
+		}
+		// A frame image/jpeg decodes must stay valid.
+		if _, err := jpeg.Decode(bytes.NewReader(b)); err != nil {
+			return
+		}
+		if _, err := jpeg.Decode(bytes.NewReader(out)); err != nil {
+			t.Fatalf("transformed frame does not decode: %v", err)
+		}
+	})
+}
@@ -0,0 +1,205 @@
+package mjpeg
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"net/textproto"
+	"strconv"
+	"strings"
+)
+
+// WithLenientParsing make the decoder accept boundary lines with missing
+// leading "--", extra dashes or trailing whitespace, and lines terminated by
+// CR only, as sent by a lot of embedded camera firmware.
+func WithLenientParsing() DecoderOption {
+	return func(o *decoderOptions) {
+		o.lenient = true
+	}
+}
+
+var errMalformedPart = errors.New("mjpeg: malformed part")
+
+type lenientSource struct {
+	br       *bufio.Reader
+	boundary string
+	started  bool
+	limit    int
+}
+
+func newLenientSource(r io.Reader, boundary string, limit int) *lenientSource {
+	return &lenientSource{
+		br:       bufio.NewReader(r),
+		boundary: strings.Trim(strings.TrimSpace(boundary), "-"),
+		limit:    limit,
+	}
+}
+
+// isBoundary reports whether line is a boundary line, and whether it is the
+// closing one.
+func (s *lenientSource) isBoundary(line []byte) (ok, last bool) {
+	line = bytes.TrimSpace(line)
+	if len(line) == 0 {
+		return false, false
+	}
+	if s.boundary == "" {
+		if !bytes.HasPrefix(line, []byte("--")) {
+			return false, false
+		}
+		s.boundary = string(bytes.Trim(line, "-"))
+	}
+	t := bytes.TrimLeft(line, "-")
+	if !bytes.HasPrefix(t, []byte(s.boundary)) {
+		return false, false
+	}
+	rest := t[len(s.boundary):]
+	if len(rest) == 0 {
+		return true, false
+	}
+	if len(bytes.Trim(rest, "-")) == 0 {
+		return true, true
+	}
+	return false, false
+}
+
+// readLine returns the next line including its terminator, which is any of
+// "\r\n", "\n" or "\r".
+func (s *lenientSource) readLine() ([]byte, error) {
+	var line []byte
+	for {
+		if _, err := s.br.Peek(1); err != nil {
+			if err == io.EOF && len(line) > 0 {
+				return line, nil
+			}
+			return line, err
+		}
+		buf, _ := s.br.Peek(s.br.Buffered())
+		i := bytes.IndexAny(buf, "\r\n")
+		if i < 0 {
+			line = append(line, buf...)
+			s.br.Discard(len(buf))
+			continue
+		}
+		line = append(line, buf[:i+1]...)
+		s.br.Discard(i + 1)
+		if buf[i] == '\r' {
+			if b, err := s.br.Peek(1); err == nil && b[0] == '\n' {
+				line = append(line, '\n')
+				s.br.Discard(1)
+			}
+		}
+		return line, nil
+	}
+}
+
+func trimEOL(b []byte) []byte {
+	b = bytes.TrimSuffix(b, []byte("\n"))
+	return bytes.TrimSuffix(b, []byte("\r"))
+}
+
+func (s *lenientSource) skip() error {
+	p, err := s.next()
+	if err != nil {
+		return err
+	}
+	p.release()
+	return nil
+}
+
+func (s *lenientSource) next() (*part, error) {
+	p, err := s.parse()
+	if err != nil {
+		// Scan for the next boundary line on the following call.
+		s.started = false
+	}
+	return p, err
+}
+
+func (s *lenientSource) parse() (*part, error) {
+	if !s.started {
+		for {
+			line, err := s.readLine()
+			if err != nil {
+				return nil, err
+			}
+			if ok, last := s.isBoundary(line); ok {
+				if last {
+					return nil, io.EOF
+				}
+				break
+			}
+		}
+		s.started = true
+	}
+
+	header := textproto.MIMEHeader{}
+	for {
+		line, err := s.readLine()
+		if err != nil {
+			return nil, err
+		}
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			break
+		}
+		i := bytes.IndexByte(line, ':')
+		if i < 0 {
+			return nil, errMalformedPart
+		}
+		key := textproto.CanonicalMIMEHeaderKey(string(bytes.TrimSpace(line[:i])))
+		header.Add(key, string(bytes.TrimSpace(line[i+1:])))
+	}
+
+	p := newPart()
+	p.header = header
+	if n, err := strconv.Atoi(header.Get("Content-Length")); err == nil && n >= 0 {
+		if exceeds(n, s.limit) {
+			p.release()
+			return nil, &FrameSizeError{Limit: s.limit}
+		}
+		if cap(p.data) < n {
+			p.data = make([]byte, n)
+		}
+		p.data = p.data[:n]
+		if _, err := io.ReadFull(s.br, p.data); err != nil {
+			p.release()
+			return nil, err
+		}
+		for {
+			line, err := s.readLine()
+			if err != nil {
+				p.release()
+				return nil, err
+			}
+			if ok, last := s.isBoundary(line); ok {
+				if last {
+					s.started = false
+				}
+				return p, nil
+			}
+		}
+	}
+
+	var prev []byte
+	for {
+		line, err := s.readLine()
+		if err != nil {
+			p.release()
+			return nil, err
+		}
+		if ok, last := s.isBoundary(line); ok {
+			if last {
+				s.started = false
+			}
+			p.data = append(p.data, trimEOL(prev)...)
+			return p, nil
+		}
+		p.data = append(p.data, prev...)
+		prev = line
+		if exceeds(len(p.data), s.limit) {
+			p.release()
+			return nil, &FrameSizeError{Limit: s.limit}
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package mjpeg
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+)
+
+// Logger receives the messages of a Stream. A *logrus.Logger satisfies it.
+type Logger interface {
+	Debugf(format string, args ...any)
+	Warnf(format string, args ...any)
+	Errorf(format string, args ...any)
+}
+
+// WithLogger sets the logger of the stream, which logs nothing by default.
+// The fields of the messages are appended as key=value.
+func WithLogger(l Logger) StreamOption {
+	return func(s *Stream) {
+		if l == nil {
+			l = nopLogger{}
+		}
+		s.log = l
+	}
+}
+
+// WithSlog makes the stream log structured records to l instead of the
+// Logger, with the fields stream, watcher, remote, size and error.
+func WithSlog(l *slog.Logger) StreamOption {
+	return func(s *Stream) {
+		s.slog = l
+	}
+}
+
+// WithName sets the name of the stream, logged in the field stream.
+func WithName(name string) StreamOption {
+	return func(s *Stream) {
+		s.name = name
+	}
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Debugf(string, ...any) {}
+func (nopLogger) Warnf(string, ...any)  {}
+func (nopLogger) Errorf(string, ...any) {}
+
+// logAt logs msg with the key and value pairs args.
+func (s *Stream) logAt(ctx context.Context, level slog.Level, msg string, args ...any) {
+	if s.slog != nil {
+		if !s.slog.Enabled(ctx, level) {
+			return
+		}
+		if s.name != "" {
+			args = append([]any{"stream", s.name}, args...)
+		}
+		s.slog.Log(ctx, level, msg, args...)
+		return
+	}
+	if _, ok := s.log.(nopLogger); ok {
+		return
+	}
+
+	var b strings.Builder
+	b.WriteString("[MJPEG] ")
+	b.WriteString(msg)
+	if s.name != "" {
+		fmt.Fprintf(&b, " stream=%s", s.name)
+	}
+	for i := 0; i+1 < len(args); i += 2 {
+		fmt.Fprintf(&b, " %v=%v", args[i], args[i+1])
+	}
+	switch {
+	case level >= slog.LevelError:
+		s.log.Errorf("%s", b.String())
+	case level >= slog.LevelWarn:
+		s.log.Warnf("%s", b.String())
+	default:
+		s.log.Debugf("%s", b.String())
+	}
+}
@@ -0,0 +1,102 @@
+// Package metrics exports the counters of mjpeg streams and decoders as
+// Prometheus collectors.
+//
+//	prometheus.MustRegister(metrics.NewStreamCollector("front", stream))
+//	prometheus.MustRegister(metrics.NewDecoderCollector("front", decoder))
+package metrics
+
+import (
+	mjpeg "github.com/WarehouseRobotics/go-mjpeg"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	streamLabels  = []string{"stream"}
+	decoderLabels = []string{"source"}
+)
+
+var (
+	clientsDesc = prometheus.NewDesc("mjpeg_stream_clients",
+		"Number of viewers and subscriptions of the stream.", streamLabels, nil)
+	publishedDesc = prometheus.NewDesc("mjpeg_stream_frames_published_total",
+		"Frames published to the clients of the stream.", streamLabels, nil)
+	droppedDesc = prometheus.NewDesc("mjpeg_stream_frames_dropped_total",
+		"Frames not delivered to a client of the stream.", streamLabels, nil)
+	sentDesc = prometheus.NewDesc("mjpeg_stream_bytes_sent_total",
+		"Frame bytes written to the viewers of the stream.", streamLabels, nil)
+	writeErrorsDesc = prometheus.NewDesc("mjpeg_stream_write_errors_total",
+		"Failed writes to the viewers of the stream.", streamLabels, nil)
+
+	decodedDesc = prometheus.NewDesc("mjpeg_decoder_frames_total",
+		"Frames returned by the decoder.", decoderLabels, nil)
+	skippedDesc = prometheus.NewDesc("mjpeg_decoder_frames_skipped_total",
+		"Frames dropped by the decoder.", decoderLabels, nil)
+	readDesc = prometheus.NewDesc("mjpeg_decoder_bytes_read_total",
+		"Frame bytes read by the decoder.", decoderLabels, nil)
+	decodeErrorsDesc = prometheus.NewDesc("mjpeg_decoder_errors_total",
+		"Read and decode errors of the decoder.", decoderLabels, nil)
+	corruptDesc = prometheus.NewDesc("mjpeg_decoder_corrupt_total",
+		"Parts skipped by the frame validation of the decoder.", decoderLabels, nil)
+	reconnectsDesc = prometheus.NewDesc("mjpeg_decoder_reconnects_total",
+		"Connections made again after the stream broke.", decoderLabels, nil)
+)
+
+// StreamCollector collects the counters of a Stream. The counters of each
+// viewer are left to Stream.Watchers, a series per connection would grow
+// without bound.
+type StreamCollector struct {
+	name string
+	s    *mjpeg.Stream
+}
+
+// NewStreamCollector return a collector of s, labeled stream=name.
+func NewStreamCollector(name string, s *mjpeg.Stream) *StreamCollector {
+	return &StreamCollector{name: name, s: s}
+}
+
+func (c *StreamCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- clientsDesc
+	ch <- publishedDesc
+	ch <- droppedDesc
+	ch <- sentDesc
+	ch <- writeErrorsDesc
+}
+
+func (c *StreamCollector) Collect(ch chan<- prometheus.Metric) {
+	st := c.s.Stats()
+	ch <- prometheus.MustNewConstMetric(clientsDesc, prometheus.GaugeValue, float64(st.Clients), c.name)
+	ch <- prometheus.MustNewConstMetric(publishedDesc, prometheus.CounterValue, float64(st.Published), c.name)
+	ch <- prometheus.MustNewConstMetric(droppedDesc, prometheus.CounterValue, float64(st.Dropped), c.name)
+	ch <- prometheus.MustNewConstMetric(sentDesc, prometheus.CounterValue, float64(st.Bytes), c.name)
+	ch <- prometheus.MustNewConstMetric(writeErrorsDesc, prometheus.CounterValue, float64(st.Errors), c.name)
+}
+
+// DecoderCollector collects the counters of a Decoder.
+type DecoderCollector struct {
+	name string
+	d    *mjpeg.Decoder
+}
+
+// NewDecoderCollector return a collector of d, labeled source=name.
+func NewDecoderCollector(name string, d *mjpeg.Decoder) *DecoderCollector {
+	return &DecoderCollector{name: name, d: d}
+}
+
+func (c *DecoderCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- decodedDesc
+	ch <- skippedDesc
+	ch <- readDesc
+	ch <- decodeErrorsDesc
+	ch <- corruptDesc
+	ch <- reconnectsDesc
+}
+
+func (c *DecoderCollector) Collect(ch chan<- prometheus.Metric) {
+	st := c.d.Stats()
+	ch <- prometheus.MustNewConstMetric(decodedDesc, prometheus.CounterValue, float64(st.Frames), c.name)
+	ch <- prometheus.MustNewConstMetric(skippedDesc, prometheus.CounterValue, float64(st.Skipped), c.name)
+	ch <- prometheus.MustNewConstMetric(readDesc, prometheus.CounterValue, float64(st.Bytes), c.name)
+	ch <- prometheus.MustNewConstMetric(decodeErrorsDesc, prometheus.CounterValue, float64(st.Errors), c.name)
+	ch <- prometheus.MustNewConstMetric(corruptDesc, prometheus.CounterValue, float64(st.Corrupt), c.name)
+	ch <- prometheus.MustNewConstMetric(reconnectsDesc, prometheus.CounterValue, float64(st.Reconnects), c.name)
+}
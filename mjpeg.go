@@ -1,183 +1,464 @@
 package mjpeg
 
 import (
+	"bufio"
+	"context"
 	"errors"
 	"fmt"
 	"image"
-	"image/jpeg"
 	"io"
-	"mime"
-	"mime/multipart"
+	"iter"
 	"net/http"
 	"net/textproto"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
-	log "github.com/sirupsen/logrus"
 )
 
+// ErrDecoderClosed is returned when reading from a closed Decoder.
+var ErrDecoderClosed = errors.New("mjpeg: decoder closed")
+
+// ErrStalled is returned when no complete part arrived within the frame timeout.
+var ErrStalled = errors.New("mjpeg: stream stalled")
+
 // Decoder decode motion jpeg
 type Decoder struct {
-	r *multipart.Reader
-	m sync.Mutex
+	src  partSource
+	m    sync.Mutex
+	opts decoderOptions
+
+	// body is closed by Close, it is guarded by bm as reconnect swaps it.
+	body io.ReadCloser
+	bm   sync.Mutex
+
+	done      chan struct{}
+	closeOnce sync.Once
+
+	// lastPart is the time of the last part read, used by WithMaxFPS.
+	lastPart time.Time
+
+	hooks []func(*Frame)
+	hm    sync.Mutex
+
+	stats      decoderStats
+	lastHeader atomic.Value
+
+	// connect is set for reconnecting decoders, connected tells whether the
+	// last attempt succeeded.
+	connect   func() (*Decoder, error)
+	connected atomic.Bool
+
+	seq uint64
+
+	// pending holds a part read that was started by a cancelled DecodeContext.
+	pending chan partResult
+
+	// latest is fed by the background reader in skip-to-latest mode.
+	latest    chan partResult
+	latestErr error
+}
+
+type part struct {
+	header textproto.MIMEHeader
+	data   []byte
+
+	// skipped is the number of parts dropped in favour of this one.
+	skipped  int
+	received time.Time
+
+	// pooled is the pool buffer data was taken from, see release.
+	pooled *[]byte
+
+	frame *Frame
+}
+
+type partResult struct {
+	p   *part
+	err error
 }
 
 // NewDecoder return new instance of Decoder
-func NewDecoder(r io.Reader, b string) *Decoder {
-	d := new(Decoder)
-	d.r = multipart.NewReader(r, b)
+func NewDecoder(r io.Reader, b string, opts ...DecoderOption) *Decoder {
+	return newDecoder(r, b, newDecoderOptions(opts))
+}
+
+func newDecoder(r io.Reader, b string, o decoderOptions) *Decoder {
+	d := &Decoder{opts: o, done: make(chan struct{})}
+	if d.opts.raw {
+		d.src = newRawSource(r, o.maxFrameSize)
+	} else if d.opts.lenient {
+		d.src = newLenientSource(r, b, o.maxFrameSize)
+	} else {
+		d.src = newMultipartSource(r, b, o.maxFrameSize)
+	}
 	return d
 }
 
 // NewDecoderFromResponse return new instance of Decoder from http.Response
-func NewDecoderFromResponse(res *http.Response) (*Decoder, error) {
-	_, param, err := mime.ParseMediaType(res.Header.Get("Content-Type"))
-	if err != nil {
+func NewDecoderFromResponse(res *http.Response, opts ...DecoderOption) (*Decoder, error) {
+	o := newDecoderOptions(opts)
+	br := bufio.NewReaderSize(res.Body, sniffLen)
+	mediatype, boundary, err := parseContentType(res.Header.Get("Content-Type"))
+	kind := contentTypes[mediatype]
+	if kind == kindJPEG || (kind != kindMultipart && !o.raw && startsWithSOI(br)) {
+		o.raw = true
+	}
+	// Vendors announcing the boundary with its dashes disagree on how many
+	// the body carries, take it from the body.
+	vendor := strings.HasPrefix(boundary, "-")
+	boundary = strings.Trim(boundary, "-")
+	if !o.raw && (boundary == "" || o.sniff || vendor) {
+		if b, ok := sniffBoundary(br); ok {
+			boundary, err = b, nil
+		}
+	}
+	if err != nil && !o.raw {
+		res.Body.Close()
 		return nil, err
 	}
-	return NewDecoder(res.Body, strings.Trim(param["boundary"], "-")), nil
+	if boundary == "" && !o.raw && !o.lenient {
+		res.Body.Close()
+		return nil, fmt.Errorf("mjpeg: no boundary in content type %q nor in the body", res.Header.Get("Content-Type"))
+	}
+	d := newDecoder(br, boundary, o)
+	d.body = res.Body
+	return d, nil
 }
 
 // NewDecoderFromURL return new instance of Decoder from response which specified URL
-func NewDecoderFromURL(u string) (*Decoder, error) {
-	req, err := http.NewRequest("GET", u, nil)
-	if err != nil {
-		return nil, err
-	}
-	res, err := http.DefaultClient.Do(req)
+func NewDecoderFromURL(u string, opts ...DecoderOption) (*Decoder, error) {
+	o := newDecoderOptions(opts)
+	res, err := o.get(u)
 	if err != nil {
 		return nil, err
 	}
-	return NewDecoderFromResponse(res)
+	return NewDecoderFromResponse(res, opts...)
 }
 
-// Decode do decoding
+// Decode do decoding. When a part is malformed, the error is returned and
+// the next call resynchronizes on the following boundary.
 func (d *Decoder) Decode() (image.Image, error) {
-	p, err := d.r.NextPart()
+	return d.DecodeContext(context.Background())
+}
+
+// DecodeContext do decoding like Decode, but returns ctx.Err() when ctx is done
+// before the next part arrives. A read interrupted this way is not lost, the
+// following call picks it up.
+func (d *Decoder) DecodeContext(ctx context.Context) (image.Image, error) {
+	p, err := d.nextPart(ctx)
 	if err != nil {
 		return nil, err
 	}
-	return jpeg.Decode(p)
+	img, err := decodeImage(d.opts.jpegCodec(), p.data)
+	p.release()
+	if err != nil {
+		d.stats.errors.Add(1)
+	}
+	return img, err
 }
 
-type Stream struct {
-	m        sync.Mutex
-	s        map[chan []byte]struct{}
-	Interval time.Duration
+// DecodeConfig return the color model and dimensions of the next frame, parsing
+// only its image headers. The frame is consumed.
+func (d *Decoder) DecodeConfig() (image.Config, error) {
+	p, err := d.nextPart(context.Background())
+	if err != nil {
+		return image.Config{}, err
+	}
+	defer p.release()
+	return decodeImageConfig(d.opts.jpegCodec(), p.data)
 }
 
-func NewStream() *Stream {
-	return &Stream{
-		s: make(map[chan []byte]struct{}),
+// DecodeRaw return raw bytes of the next part and its MIME headers without decoding JPEG
+func (d *Decoder) DecodeRaw() ([]byte, textproto.MIMEHeader, error) {
+	p, err := d.nextPart(context.Background())
+	if err != nil {
+		return nil, nil, err
 	}
+	return p.data, p.header, nil
 }
 
-func NewStreamWithInterval(interval time.Duration) *Stream {
-	return &Stream{
-		s:        make(map[chan []byte]struct{}),
-		Interval: interval,
+// LastHeaders return the MIME headers of the most recently returned part, e.g.
+// X-Timestamp or motion flags sent by the camera, or nil before the first part.
+// Frames returned by DecodeFrame carry the same headers in Frame.Header.
+func (d *Decoder) LastHeaders() textproto.MIMEHeader {
+	h, _ := d.lastHeader.Load().(textproto.MIMEHeader)
+	return h
+}
+
+// DecodeRawInto is like DecodeRaw but copies the part into buf, growing it when
+// needed, so that the read buffers are reused instead of allocated per frame.
+func (d *Decoder) DecodeRawInto(buf []byte) ([]byte, textproto.MIMEHeader, error) {
+	p, err := d.nextPart(context.Background())
+	if err != nil {
+		return nil, nil, err
 	}
+	buf = append(buf[:0], p.data...)
+	header := p.header
+	p.release()
+	return buf, header, nil
 }
 
-func (s *Stream) Close() error {
-	log.Warn("[MJPEG] Closing stream")
+// DecodeFrame return the next frame with its metadata. The image is decoded lazily by Frame.Image.
+func (d *Decoder) DecodeFrame() (*Frame, error) {
+	return d.decodeFrame(context.Background())
+}
 
-	s.m.Lock()
-	defer s.m.Unlock()
-	for c := range s.s {
-		close(c)
-		delete(s.s, c)
+func (d *Decoder) decodeFrame(ctx context.Context) (*Frame, error) {
+	p, err := d.nextPart(ctx)
+	if err != nil {
+		return nil, err
 	}
-	s.s = nil
-	return nil
+	return p.frame, nil
 }
 
-func (s *Stream) Update(b []byte) error {
-	s.m.Lock()
-	defer s.m.Unlock()
-	if s.s == nil {
-		return errors.New("stream was closed")
+// OnFrame register f to be called with every frame read, by any of the
+// decoding methods, before the frame is returned. f may modify the header of
+// the frame, which is then returned by DecodeRaw and LastHeaders as well.
+// Unless the frame is returned by DecodeFrame, Frames or All, its Data is only
+// valid during the call and must be copied to be retained. f must not call
+// methods of the decoder.
+func (d *Decoder) OnFrame(f func(*Frame)) {
+	d.hm.Lock()
+	d.hooks = append(d.hooks, f)
+	d.hm.Unlock()
+}
+
+// newFrame wraps p in a Frame and runs the OnFrame hooks. It must be called with d.m held.
+func (d *Decoder) newFrame(p *part) *Frame {
+	d.seq++
+	f := &Frame{
+		Data:      p.data,
+		Header:    p.header,
+		Seq:       d.seq,
+		SourceSeq: frameSeq(p.header),
+		Time:      p.received,
+		Skipped:   p.skipped,
+		codec:     d.opts.jpegCodec(),
 	}
-	for c := range s.s {
-		select {
-		case c <- b:
-		default:
-		}
+	d.hm.Lock()
+	hooks := d.hooks
+	d.hm.Unlock()
+	for _, h := range hooks {
+		h(f)
 	}
-	return nil
+	p.header = f.Header
+	return f
 }
 
-func (s *Stream) add(c chan []byte) {
-	s.m.Lock()
-	s.s[c] = struct{}{}
-	s.m.Unlock()
+// Frames start reading frames in background and deliver them on the returned
+// channel until ctx is done or reading fails. The error which stopped the loop,
+// if any other than ctx.Err(), is sent on the error channel. Both channels are
+// closed when the loop exits.
+func (d *Decoder) Frames(ctx context.Context) (<-chan *Frame, <-chan error) {
+	fc := make(chan *Frame)
+	ec := make(chan error, 1)
+	go func() {
+		defer close(ec)
+		defer close(fc)
+		for {
+			f, err := d.decodeFrame(ctx)
+			if err != nil {
+				if ctx.Err() == nil {
+					ec <- err
+				}
+				return
+			}
+			select {
+			case fc <- f:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return fc, ec
 }
 
-func (s *Stream) destroy(c chan []byte) {
-	s.m.Lock()
-	if s.s != nil {
-		close(c)
-		delete(s.s, c)
+// All return an iterator over the frames of the stream. Iteration stops at the
+// end of the stream, or after yielding the first error.
+func (d *Decoder) All() iter.Seq2[*Frame, error] {
+	return func(yield func(*Frame, error) bool) {
+		for {
+			f, err := d.DecodeFrame()
+			if err == io.EOF {
+				return
+			}
+			if !yield(f, err) || err != nil {
+				return
+			}
+		}
 	}
-	s.m.Unlock()
 }
 
-func (s *Stream) NWatch() int {
-	return len(s.s)
-}
+// nextPart reads the next whole part from the stream.
+func (d *Decoder) nextPart(ctx context.Context) (*part, error) {
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	select {
+	case <-d.done:
+		return nil, ErrDecoderClosed
+	default:
+	}
+
+	var results <-chan partResult
+	if d.opts.latest {
+		if d.latestErr != nil {
+			return nil, d.latestErr
+		}
+		if d.latest == nil {
+			d.latest = make(chan partResult, 1)
+			go d.readLatest(d.latest)
+		}
+		results = d.latest
+	} else {
+		if d.pending == nil {
+			ch := make(chan partResult, 1)
+			go func() {
+				p, err := d.readPart()
+				ch <- partResult{p, err}
+			}()
+			d.pending = ch
+		}
+		results = d.pending
+	}
 
-func (s *Stream) Current() []byte {
-	c := make(chan []byte)
-	s.add(c)
-	defer s.destroy(c)
+	var stalled <-chan time.Time
+	if d.opts.frameTimeout > 0 {
+		t := time.NewTimer(d.opts.frameTimeout)
+		defer t.Stop()
+		stalled = t.C
+	}
 
-	return <-c
+	select {
+	case res := <-results:
+		if d.opts.latest {
+			d.latestErr = res.err
+		} else {
+			d.pending = nil
+		}
+		if res.err != nil {
+			if res.err != io.EOF {
+				d.stats.errors.Add(1)
+			}
+		} else {
+			d.stats.frame(res.p)
+			res.p.frame = d.newFrame(res.p)
+			d.lastHeader.Store(res.p.header)
+		}
+		return res.p, res.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-d.done:
+		return nil, ErrDecoderClosed
+	case <-stalled:
+		d.dropConnection()
+		return nil, ErrStalled
+	}
 }
 
-func (s *Stream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	c := make(chan []byte)
-	s.add(c)
-	defer s.destroy(c)
+// readLatest keeps reading parts into ch, replacing the part which was not
+// taken yet so that ch always holds the most recent one.
+func (d *Decoder) readLatest(ch chan partResult) {
+	for {
+		p, err := d.readPart()
+		res := partResult{p, err}
+		select {
+		case ch <- res:
+		default:
+			select {
+			case old := <-ch:
+				if p != nil && old.p != nil {
+					p.skipped += old.p.skipped + 1
+					old.p.release()
+				}
+			default:
+			}
+			ch <- res
+		}
+		if err != nil {
+			return
+		}
+	}
+}
 
-	m := multipart.NewWriter(w)
-	defer m.Close()
+// dropConnection closes the owned body so that a read blocked on it returns.
+// A reconnecting decoder connects again on the next read.
+func (d *Decoder) dropConnection() {
+	d.bm.Lock()
+	if d.body != nil {
+		d.body.Close()
+		d.body = nil
+	}
+	d.bm.Unlock()
+}
 
-	w.Header().Set("Content-Type", "multipart/x-mixed-replace; boundary="+m.Boundary())
-	w.Header().Set("Connection", "close")
-	header := textproto.MIMEHeader{}
-	starttime := fmt.Sprint(time.Now().Unix())
+// Close release the response body when the decoder owns it. Decode and the
+// other reading methods return ErrDecoderClosed afterwards.
+func (d *Decoder) Close() error {
+	var err error
+	d.closeOnce.Do(func() {
+		close(d.done)
+		d.bm.Lock()
+		if d.body != nil {
+			err = d.body.Close()
+			d.body = nil
+		}
+		d.bm.Unlock()
+		if d.opts.transport != nil {
+			d.opts.transport.CloseIdleConnections()
+		}
+	})
+	return err
+}
 
+func (d *Decoder) readPart() (*part, error) {
+	if d.connect == nil {
+		return d.readPartOnce()
+	}
+	var cause error
 	for {
-		time.Sleep(s.Interval)
-
-		b, errch := <-c
-		if !errch {
-			log.Errorf("[MJPEG] Channel error: %s", errch)
-			continue
+		if d.src != nil {
+			p, err := d.readPartOnce()
+			if err == nil {
+				return p, nil
+			}
+			cause = err
 		}
+		if err := d.reconnect(cause); err != nil {
+			return nil, err
+		}
+	}
+}
 
-		header.Set("Content-Type", "image/jpeg")
-		header.Set("Content-Length", fmt.Sprint(len(b)))
-		header.Set("X-StartTime", starttime)
-		header.Set("X-TimeStamp", fmt.Sprint(time.Now().Unix()))
-		mw, err := m.CreatePart(header)
-		if err != nil {
-			log.Errorf("[MJPEG] Enc err: %s", err)
+func (d *Decoder) readPartOnce() (*part, error) {
+	for {
+		if d.opts.maxFPS > 0 && time.Since(d.lastPart) < time.Duration(float64(time.Second)/d.opts.maxFPS) {
+			if err := d.src.skip(); err != nil {
+				return nil, err
+			}
+			d.stats.skipped.Add(1)
 			continue
 		}
-		_, err = mw.Write(b)
+		p, err := d.src.next()
 		if err != nil {
-			log.Errorf("[MJPEG] Write err: %s", err)
-
-			if flusher, ok := mw.(http.Flusher); ok {
-				flusher.Flush()
+			return nil, err
+		}
+		if d.opts.skipNonImage && !isImagePart(p) {
+			if d.opts.onNonImage != nil {
+				d.opts.onNonImage(p.header, p.data)
+			} else {
+				p.release()
 			}
-			break // Stop and close if the writer is not available any more
+			continue
 		}
-		if flusher, ok := mw.(http.Flusher); ok {
-			flusher.Flush()
+		if d.opts.validate && !validPart(p) {
+			d.stats.corrupt.Add(1)
+			p.release()
+			continue
 		}
+		p.received = time.Now()
+		d.lastPart = p.received
+		return p, nil
 	}
-
-	log.Debug("[MJPEG] exiting stream")
 }
@@ -0,0 +1,189 @@
+package mjpeg
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"image/draw"
+	"sync"
+	"time"
+)
+
+// MosaicTile is a stream shown by a Mosaic.
+type MosaicTile struct {
+	Stream *Stream
+	// Rect is the position of the tile in the mosaic. When it is empty, the
+	// tile gets the next cell of the grid.
+	Rect image.Rectangle
+	// Label, if not empty, is written in the top left corner of the tile.
+	Label string
+}
+
+// MosaicOption is an option of NewMosaic
+type MosaicOption func(*Mosaic)
+
+// WithMosaicSize sets the resolution of the mosaic, 1280x720 by default.
+func WithMosaicSize(w, h int) MosaicOption {
+	return func(m *Mosaic) {
+		if w > 0 && h > 0 {
+			m.size = image.Pt(w, h)
+		}
+	}
+}
+
+// WithMosaicColumns sets the number of columns of the grid of the tiles
+// without Rect. By default the grid is as square as possible.
+func WithMosaicColumns(n int) MosaicOption {
+	return func(m *Mosaic) {
+		m.columns = n
+	}
+}
+
+// WithMosaicFPS sets the rate at which the mosaic is composed, 10 frames per
+// second by default.
+func WithMosaicFPS(fps float64) MosaicOption {
+	return func(m *Mosaic) {
+		if fps > 0 {
+			m.interval = time.Duration(float64(time.Second) / fps)
+		}
+	}
+}
+
+// Mosaic composes the frames of several streams into a grid published to
+// another stream, like a video wall.
+type Mosaic struct {
+	out      *Stream
+	tiles    []MosaicTile
+	size     image.Point
+	columns  int
+	interval time.Duration
+
+	// m guards the last frame of each tile, decoded when composing.
+	m      sync.Mutex
+	frames []*Frame
+}
+
+// NewMosaic return new instance of Mosaic of tiles, published to out by Run.
+func NewMosaic(out *Stream, tiles []MosaicTile, opts ...MosaicOption) *Mosaic {
+	m := &Mosaic{
+		out:      out,
+		tiles:    append([]MosaicTile(nil), tiles...),
+		size:     image.Pt(1280, 720),
+		interval: 100 * time.Millisecond,
+		frames:   make([]*Frame, len(tiles)),
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	m.layout()
+	return m
+}
+
+// layout places the tiles without Rect on the grid.
+func (m *Mosaic) layout() {
+	n := 0
+	for _, t := range m.tiles {
+		if t.Rect.Empty() {
+			n++
+		}
+	}
+	if n == 0 {
+		return
+	}
+	cols := m.columns
+	if cols <= 0 {
+		for cols = 1; cols*cols < n; cols++ {
+		}
+	}
+	rows := (n + cols - 1) / cols
+	w, h := m.size.X/cols, m.size.Y/rows
+	i := 0
+	for k := range m.tiles {
+		if !m.tiles[k].Rect.Empty() {
+			continue
+		}
+		x, y := i%cols*w, i/cols*h
+		m.tiles[k].Rect = image.Rect(x, y, x+w, y+h)
+		i++
+	}
+}
+
+// Run composes the mosaic until ctx is done or the output stream is closed.
+// A tile keeps its last frame when its stream stops.
+func (m *Mosaic) Run(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for i, t := range m.tiles {
+		sub, err := t.Stream.Subscribe()
+		if err != nil {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer sub.Close()
+			m.follow(ctx, i, sub)
+		}()
+	}
+
+	canvas := image.NewRGBA(image.Rectangle{Max: m.size})
+	tick := time.NewTicker(m.interval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-tick.C:
+		}
+		m.compose(canvas)
+		if err := m.out.UpdateImage(canvas); err == ErrStreamClosed {
+			return nil
+		}
+	}
+}
+
+// follow keeps the last frame of the tile i.
+func (m *Mosaic) follow(ctx context.Context, i int, sub *Subscription) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case f, ok := <-sub.C:
+			if !ok {
+				return
+			}
+			m.m.Lock()
+			m.frames[i] = f
+			m.m.Unlock()
+		}
+	}
+}
+
+var (
+	mosaicBackground = image.NewUniform(color.Gray{Y: 0x10})
+	labelBackground  = image.NewUniform(color.RGBA{A: 0xa0})
+)
+
+func (m *Mosaic) compose(canvas *image.RGBA) {
+	draw.Draw(canvas, canvas.Rect, mosaicBackground, image.Point{}, draw.Src)
+	m.m.Lock()
+	frames := append([]*Frame(nil), m.frames...)
+	m.m.Unlock()
+	for i, t := range m.tiles {
+		if f := frames[i]; f != nil {
+			if img, err := f.Image(); err == nil {
+				b := img.Bounds()
+				scaleInto(canvas, fitRect(t.Rect, b.Dx(), b.Dy()), img)
+			}
+		}
+		if t.Label != "" {
+			scale := max(1, t.Rect.Dy()/120)
+			p := t.Rect.Min.Add(image.Pt(glyphAdvance*scale, glyphAdvance*scale))
+			box := image.Rect(p.X-2*scale, p.Y-2*scale, p.X+textWidth(t.Label, scale)+2*scale, p.Y+(glyphHeight+2)*scale)
+			draw.Draw(canvas, box.Intersect(t.Rect), labelBackground, image.Point{}, draw.Over)
+			drawText(canvas, p, scale, t.Label, color.White)
+		}
+	}
+}
@@ -0,0 +1,296 @@
+package mjpeg
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/WarehouseRobotics/go-mjpeg/internal/timescale"
+)
+
+// mp4Timescale is the number of units per second of the track, 90 kHz like
+// RTP video.
+const mp4Timescale = 90000
+
+// WithMP4SampleEntry sets the type of the sample entry of ServeMP4, "jpeg"
+// by default, the QuickTime Photo-JPEG which ffmpeg, VLC and most NVRs read.
+// Some players want "mjpg" instead.
+func WithMP4SampleEntry(fourcc string) StreamOption {
+	return func(s *Stream) {
+		s.mp4Entry = fourcc
+	}
+}
+
+// ServeMP4 sends the frames to the viewer of r as a fragmented MP4 with a
+// single video track, which JPEG samples are not transcoded. The size of the
+// track is the size of the first frame. Each frame is a fragment, timed by
+// the time it is sent. The policies of ServeHTTP apply.
+func (s *Stream) ServeMP4(w http.ResponseWriter, r *http.Request) {
+	s.serve(w, r, s.openMP4)
+}
+
+// openMP4 starts the video/mp4 response of ServeMP4.
+func (s *Stream) openMP4(w http.ResponseWriter, r *http.Request, c *client) (viewerConn, context.Context, error) {
+	w.Header().Set("Content-Type", "video/mp4")
+	w.Header().Set("Cache-Control", "no-cache")
+	entry := s.mp4Entry
+	if len(entry) != 4 {
+		entry = "jpeg"
+	}
+	return &mp4Conn{
+		w:     w,
+		rc:    http.NewResponseController(w),
+		buf:   partBufs.Get().(*[]byte),
+		entry: entry,
+	}, r.Context(), nil
+}
+
+// mp4Conn is the response of ServeMP4. The initialization segment is
+// written with the first frame, each fragment with a single Write.
+type mp4Conn struct {
+	w     io.Writer
+	rc    *http.ResponseController
+	buf   *[]byte
+	entry string
+
+	seq   uint32
+	start time.Time
+	// last is the decode time of the last sample, its duration is the
+	// duration of the previous one.
+	last, duration uint64
+}
+
+func (m *mp4Conn) writePart(f *Frame, now time.Time) (int, error) {
+	if !isJPEGType(f.contentType) {
+		return 0, nil
+	}
+	b := (*m.buf)[:0]
+	if m.seq == 0 {
+		cfg, err := decodeImageConfig(DefaultCodec, f.Data)
+		if err != nil {
+			return 0, err
+		}
+		b = appendMP4Init(b, m.entry, cfg.Width, cfg.Height)
+		m.start = now
+	}
+	m.seq++
+	t := timescale.Ticks(now.Sub(m.start), mp4Timescale)
+	if m.seq > 1 {
+		// The heartbeat and a coarse clock can repeat a time.
+		t = max(t, m.last+1)
+		m.duration = t - m.last
+	} else {
+		m.duration = mp4Timescale / 10
+	}
+	m.last = t
+	b = appendMP4Fragment(b, m.seq, t, uint32(m.duration), f.Data)
+	*m.buf = b
+	if _, err := m.w.Write(b); err != nil {
+		return 0, err
+	}
+	return len(f.Data), nil
+}
+
+// close does nothing, a fragmented MP4 ends with its last fragment.
+func (m *mp4Conn) close() error {
+	return nil
+}
+
+func (m *mp4Conn) flush() error {
+	return flush(m.rc)
+}
+
+func (m *mp4Conn) setWriteDeadline(t time.Time) error {
+	return m.rc.SetWriteDeadline(t)
+}
+
+// release returns the write buffer to partBufs.
+func (m *mp4Conn) release() {
+	if m.buf == nil {
+		return
+	}
+	if cap(*m.buf) <= maxPartBuf {
+		partBufs.Put(m.buf)
+	}
+	m.buf = nil
+}
+
+// box appends the header of a box of type typ to b and returns the offset
+// of the header, whose size end sets.
+func box(b []byte, typ string) ([]byte, int) {
+	return append(b, 0, 0, 0, 0, typ[0], typ[1], typ[2], typ[3]), len(b)
+}
+
+// fullBox is like box for a box with a version and flags.
+func fullBox(b []byte, typ string, version byte, flags uint32) ([]byte, int) {
+	b, at := box(b, typ)
+	return binary.BigEndian.AppendUint32(b, uint32(version)<<24|flags), at
+}
+
+// end sets the size of the box at offset at.
+func end(b []byte, at int) []byte {
+	binary.BigEndian.PutUint32(b[at:], uint32(len(b)-at))
+	return b
+}
+
+// unityMatrix is the transformation matrix of mvhd and tkhd.
+var unityMatrix = [9]uint32{0x00010000, 0, 0, 0, 0x00010000, 0, 0, 0, 0x40000000}
+
+func appendMatrix(b []byte) []byte {
+	for _, v := range unityMatrix {
+		b = binary.BigEndian.AppendUint32(b, v)
+	}
+	return b
+}
+
+// appendMP4Init appends the initialization segment, ftyp and moov, of a
+// width x height JPEG track.
+func appendMP4Init(b []byte, entry string, width, height int) []byte {
+	be := binary.BigEndian
+	b, ftyp := box(b, "ftyp")
+	b = append(b, "isom"...)
+	b = be.AppendUint32(b, 0x200)
+	b = append(b, "isomiso5iso6mp41"...)
+	b = end(b, ftyp)
+
+	b, moov := box(b, "moov")
+	b, mvhd := fullBox(b, "mvhd", 0, 0)
+	b = be.AppendUint32(b, 0) // creation time
+	b = be.AppendUint32(b, 0) // modification time
+	b = be.AppendUint32(b, 1000)
+	b = be.AppendUint32(b, 0) // duration
+	b = be.AppendUint32(b, 0x00010000)
+	b = be.AppendUint16(b, 0x0100)
+	b = append(b, make([]byte, 10)...)
+	b = appendMatrix(b)
+	b = append(b, make([]byte, 24)...)
+	b = be.AppendUint32(b, 2) // next track ID
+	b = end(b, mvhd)
+
+	b, trak := box(b, "trak")
+	b, tkhd := fullBox(b, "tkhd", 0, 3) // enabled, in movie
+	b = be.AppendUint32(b, 0)
+	b = be.AppendUint32(b, 0)
+	b = be.AppendUint32(b, 1) // track ID
+	b = be.AppendUint32(b, 0)
+	b = be.AppendUint32(b, 0) // duration
+	b = append(b, make([]byte, 8)...)
+	b = be.AppendUint16(b, 0) // layer
+	b = be.AppendUint16(b, 0) // alternate group
+	b = be.AppendUint16(b, 0) // volume
+	b = be.AppendUint16(b, 0)
+	b = appendMatrix(b)
+	b = be.AppendUint32(b, uint32(width)<<16)
+	b = be.AppendUint32(b, uint32(height)<<16)
+	b = end(b, tkhd)
+
+	b, mdia := box(b, "mdia")
+	b, mdhd := fullBox(b, "mdhd", 0, 0)
+	b = be.AppendUint32(b, 0)
+	b = be.AppendUint32(b, 0)
+	b = be.AppendUint32(b, mp4Timescale)
+	b = be.AppendUint32(b, 0)
+	b = be.AppendUint16(b, 0x55C4) // und
+	b = be.AppendUint16(b, 0)
+	b = end(b, mdhd)
+	b, hdlr := fullBox(b, "hdlr", 0, 0)
+	b = be.AppendUint32(b, 0)
+	b = append(b, "vide"...)
+	b = append(b, make([]byte, 12)...)
+	b = append(b, "VideoHandler\x00"...)
+	b = end(b, hdlr)
+
+	b, minf := box(b, "minf")
+	b, vmhd := fullBox(b, "vmhd", 0, 1)
+	b = append(b, make([]byte, 8)...)
+	b = end(b, vmhd)
+	b, dinf := box(b, "dinf")
+	b, dref := fullBox(b, "dref", 0, 0)
+	b = be.AppendUint32(b, 1)
+	b, url := fullBox(b, "url ", 0, 1) // data in the same file
+	b = end(b, url)
+	b = end(b, dref)
+	b = end(b, dinf)
+
+	b, stbl := box(b, "stbl")
+	b, stsd := fullBox(b, "stsd", 0, 0)
+	b = be.AppendUint32(b, 1)
+	b, se := box(b, entry)
+	b = append(b, make([]byte, 6)...)
+	b = be.AppendUint16(b, 1) // data reference index
+	b = append(b, make([]byte, 16)...)
+	b = be.AppendUint16(b, uint16(width))
+	b = be.AppendUint16(b, uint16(height))
+	b = be.AppendUint32(b, 0x00480000) // 72 dpi
+	b = be.AppendUint32(b, 0x00480000)
+	b = be.AppendUint32(b, 0)
+	b = be.AppendUint16(b, 1) // frame count
+	name := make([]byte, 32)
+	name[0] = byte(copy(name[1:], "Photo - JPEG"))
+	b = append(b, name...)
+	b = be.AppendUint16(b, 0x0018) // depth
+	b = be.AppendUint16(b, 0xFFFF)
+	b = end(b, se)
+	b = end(b, stsd)
+	for _, typ := range []string{"stts", "stsc", "stco"} {
+		var at int
+		b, at = fullBox(b, typ, 0, 0)
+		b = be.AppendUint32(b, 0)
+		b = end(b, at)
+	}
+	b, stsz := fullBox(b, "stsz", 0, 0)
+	b = be.AppendUint32(b, 0)
+	b = be.AppendUint32(b, 0)
+	b = end(b, stsz)
+	b = end(b, stbl)
+	b = end(b, minf)
+	b = end(b, mdia)
+	b = end(b, trak)
+
+	b, mvex := box(b, "mvex")
+	b, trex := fullBox(b, "trex", 0, 0)
+	b = be.AppendUint32(b, 1) // track ID
+	b = be.AppendUint32(b, 1) // sample description index
+	b = be.AppendUint32(b, 0)
+	b = be.AppendUint32(b, 0)
+	b = be.AppendUint32(b, 0)
+	b = end(b, trex)
+	b = end(b, mvex)
+	return end(b, moov)
+}
+
+// appendMP4Fragment appends the fragment, moof and mdat, of a single sample
+// decoded at t lasting duration.
+func appendMP4Fragment(b []byte, seq uint32, t uint64, duration uint32, data []byte) []byte {
+	be := binary.BigEndian
+	b, moof := box(b, "moof")
+	b, mfhd := fullBox(b, "mfhd", 0, 0)
+	b = be.AppendUint32(b, seq)
+	b = end(b, mfhd)
+	b, traf := box(b, "traf")
+	b, tfhd := fullBox(b, "tfhd", 0, 0x020000) // default base is moof
+	b = be.AppendUint32(b, 1)
+	b = end(b, tfhd)
+	b, tfdt := fullBox(b, "tfdt", 1, 0)
+	b = be.AppendUint64(b, t)
+	b = end(b, tfdt)
+	// data offset, sample duration, size and flags.
+	b, trun := fullBox(b, "trun", 0, 0x000701)
+	b = be.AppendUint32(b, 1)
+	offset := len(b)
+	b = be.AppendUint32(b, 0)
+	b = be.AppendUint32(b, duration)
+	b = be.AppendUint32(b, uint32(len(data)))
+	b = be.AppendUint32(b, 0x02000000) // sync sample
+	b = end(b, trun)
+	b = end(b, traf)
+	b = end(b, moof)
+	// The data follows the header of mdat.
+	be.PutUint32(b[offset:], uint32(len(b)-moof+8))
+
+	b, mdat := box(b, "mdat")
+	b = append(b, data...)
+	return end(b, mdat)
+}
@@ -0,0 +1,136 @@
+package mjpeg
+
+import (
+	"crypto/tls"
+	"mime"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// DecoderOption configure Decoder
+type DecoderOption func(*decoderOptions)
+
+type decoderOptions struct {
+	minBackoff time.Duration
+	maxBackoff time.Duration
+	maxRetries int
+	onStatus   func(ConnStatus)
+
+	client    *http.Client
+	tlsConfig *tls.Config
+	header    http.Header
+	username  string
+	password  string
+	digest    bool
+	jar       http.CookieJar
+	proxy     *url.URL
+	redirects int
+	// httpc is the client built from the options by httpClient, shared by
+	// the connections of a reconnecting decoder. transport is set when it
+	// is a Transport of its own.
+	httpc     *http.Client
+	transport *http.Transport
+
+	sniff   bool
+	lenient bool
+	raw     bool
+
+	frameTimeout time.Duration
+	latest       bool
+
+	codec    Codec
+	validate bool
+
+	maxFrameSize int
+	maxFPS       float64
+
+	skipNonImage bool
+	onNonImage   func(textproto.MIMEHeader, []byte)
+}
+
+func newDecoderOptions(opts []DecoderOption) decoderOptions {
+	o := decoderOptions{
+		minBackoff: 500 * time.Millisecond,
+		maxBackoff: 30 * time.Second,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// WithBackoff set the delay before the first reconnect attempt and the upper
+// bound it doubles up to on consecutive failures.
+func WithBackoff(min, max time.Duration) DecoderOption {
+	return func(o *decoderOptions) {
+		o.minBackoff = min
+		o.maxBackoff = max
+	}
+}
+
+// WithMaxRetries set how many consecutive reconnect attempts are made before
+// giving up. Zero means retry forever.
+func WithMaxRetries(n int) DecoderOption {
+	return func(o *decoderOptions) {
+		o.maxRetries = n
+	}
+}
+
+// WithStatusFunc set a callback which is called on every change of the connection status.
+func WithStatusFunc(f func(ConnStatus)) DecoderOption {
+	return func(o *decoderOptions) {
+		o.onStatus = f
+	}
+}
+
+// WithFrameTimeout make Decode fail with ErrStalled when no complete part
+// arrives within d. The connection of a decoder created from URL or response
+// is dropped on stall, a reconnecting decoder connects again on the next call.
+func WithFrameTimeout(d time.Duration) DecoderOption {
+	return func(o *decoderOptions) {
+		o.frameTimeout = d
+	}
+}
+
+// WithSkipToLatest make the decoder read parts in background and return only
+// the most recent one, discarding the backlog. The number of discarded parts
+// is reported in Frame.Skipped.
+func WithSkipToLatest() DecoderOption {
+	return func(o *decoderOptions) {
+		o.latest = true
+	}
+}
+
+// WithSkipNonImage make the decoder skip parts whose Content-Type is not
+// image/*, like audio or metadata interleaved by some cameras. When f is not
+// nil it is called with every skipped part. Parts without Content-Type are
+// taken as images.
+func WithSkipNonImage(f func(header textproto.MIMEHeader, data []byte)) DecoderOption {
+	return func(o *decoderOptions) {
+		o.skipNonImage = true
+		o.onNonImage = f
+	}
+}
+
+func isImagePart(p *part) bool {
+	ct := p.header.Get("Content-Type")
+	if ct == "" {
+		return true
+	}
+	mediatype, _, err := mime.ParseMediaType(ct)
+	if err != nil {
+		mediatype = strings.ToLower(strings.TrimSpace(strings.Split(ct, ";")[0]))
+	}
+	return strings.HasPrefix(mediatype, "image/")
+}
+
+// WithMaxFPS limit the rate of frames returned by the decoder to fps. Excess
+// parts are discarded before JPEG decoding, and counted in DecoderStats.Skipped.
+func WithMaxFPS(fps float64) DecoderOption {
+	return func(o *decoderOptions) {
+		o.maxFPS = fps
+	}
+}
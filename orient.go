@@ -0,0 +1,56 @@
+package mjpeg
+
+import "image"
+
+// Orientation is a rotation or a flip of the frames, e.g. for ceiling
+// mounted cameras.
+type Orientation int
+
+const (
+	// Rotate90 rotates the frames clockwise.
+	Rotate90 Orientation = iota + 1
+	Rotate180
+	Rotate270
+	FlipHorizontal
+	FlipVertical
+)
+
+// WithOrientation rotates or flips the frames when they are published. JPEG
+// frames are transformed without loss, without decoding them, when nothing
+// else has to be drawn on them and their size is a multiple of the MCU, 8
+// or 16 pixels, along the flipped edges. They are decoded and encoded again
+// otherwise.
+func WithOrientation(o Orientation) StreamOption {
+	return func(s *Stream) {
+		s.orientation = o
+	}
+}
+
+// oriented returns img rotated or flipped by o.
+func oriented(img image.Image, o Orientation) *image.RGBA {
+	src := rgba(img)
+	w, h := src.Rect.Dx(), src.Rect.Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	if o == Rotate90 || o == Rotate270 {
+		dst = image.NewRGBA(image.Rect(0, 0, h, w))
+	}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			nx, ny := x, y
+			switch o {
+			case Rotate90:
+				nx, ny = h-1-y, x
+			case Rotate180:
+				nx, ny = w-1-x, h-1-y
+			case Rotate270:
+				nx, ny = y, w-1-x
+			case FlipHorizontal:
+				nx = w - 1 - x
+			case FlipVertical:
+				ny = h - 1 - y
+			}
+			copy(dst.Pix[dst.PixOffset(nx, ny):][:4], src.Pix[src.PixOffset(x, y):])
+		}
+	}
+	return dst
+}
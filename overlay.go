@@ -0,0 +1,159 @@
+package mjpeg
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Corner is where an Overlay is drawn on the frames.
+type Corner int
+
+const (
+	TopLeft Corner = iota
+	TopRight
+	BottomLeft
+	BottomRight
+)
+
+// Overlay is text burnt into the frames, with the built-in 5x7 font. It is
+// made by NewOverlay.
+type Overlay struct {
+	// Corner is where the text is drawn, TopLeft by default.
+	Corner Corner
+	// Scale is the size in pixels of a font pixel, 2 when 0.
+	Scale int
+	// Margin is the distance in pixels between the box and the borders of
+	// the frame, and between the box and the text.
+	Margin int
+	// Color is the color of the text, white when nil.
+	Color color.Color
+	// Background is the color of the box drawn behind the text, there is
+	// no box when nil.
+	Background color.Color
+	// Vars are given to the template as .Vars, they must not be changed
+	// once the Overlay is in use.
+	Vars map[string]string
+
+	tmpl *template.Template
+}
+
+// OverlayData is the data of the template of an Overlay.
+type OverlayData struct {
+	// Name is the name of the stream given to WithName.
+	Name string
+	// Time is the time of the frame.
+	Time time.Time
+	Vars map[string]string
+}
+
+// NewOverlay returns an Overlay drawing text, a text/template executed with
+// OverlayData for each frame, e.g. `{{.Name}} {{.Time.Format "15:04:05"}}`.
+// The text can have several lines.
+func NewOverlay(text string) (*Overlay, error) {
+	t, err := template.New("overlay").Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	return &Overlay{Margin: 4, tmpl: t}, nil
+}
+
+// WithOverlay draws o on every frame when it is published, e.g. to stamp the
+// time on the frames of a camera which does not.
+func WithOverlay(o *Overlay) StreamOption {
+	return func(s *Stream) {
+		s.overlay = o
+	}
+}
+
+// WithClientOverlay lets the viewers of ServeHTTP ask for o on the frames
+// with the query parameter overlay, ?overlay=1. Like for WithClientWidths,
+// the frames are drawn once and shared by the viewers.
+func WithClientOverlay(o *Overlay) StreamOption {
+	return func(s *Stream) {
+		s.clientOverlay = o
+	}
+}
+
+// draw draws o on dst.
+func (o *Overlay) draw(dst *image.RGBA, d OverlayData) error {
+	if o.tmpl == nil {
+		return nil
+	}
+	var buf strings.Builder
+	if err := o.tmpl.Execute(&buf, d); err != nil {
+		return err
+	}
+	lines := strings.Split(strings.TrimRight(buf.String(), "\n"), "\n")
+	scale := o.Scale
+	if scale <= 0 {
+		scale = 2
+	}
+	w := 0
+	for _, l := range lines {
+		w = max(w, textWidth(l, scale))
+	}
+	if w == 0 {
+		return nil
+	}
+	lineHeight := (glyphHeight + 2) * scale
+	h := len(lines)*lineHeight - 2*scale
+
+	m := o.Margin
+	box := image.Rect(0, 0, w+2*m, h+2*m)
+	b := dst.Bounds()
+	switch o.Corner {
+	case TopRight:
+		box = box.Add(image.Pt(b.Max.X-box.Dx()-m, b.Min.Y+m))
+	case BottomLeft:
+		box = box.Add(image.Pt(b.Min.X+m, b.Max.Y-box.Dy()-m))
+	case BottomRight:
+		box = box.Add(image.Pt(b.Max.X-box.Dx()-m, b.Max.Y-box.Dy()-m))
+	default:
+		box = box.Add(image.Pt(b.Min.X+m, b.Min.Y+m))
+	}
+	if o.Background != nil {
+		draw.Draw(dst, box, image.NewUniform(o.Background), image.Point{}, draw.Over)
+	}
+	c := o.Color
+	if c == nil {
+		c = color.White
+	}
+	p := box.Min.Add(image.Pt(m, m))
+	for _, l := range lines {
+		drawText(dst, p, scale, l, c)
+		p.Y += lineHeight
+	}
+	return nil
+}
+
+// rgba returns a copy of img which can be drawn on.
+func rgba(img image.Image) *image.RGBA {
+	b := img.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Rect, img, b.Min, draw.Src)
+	return dst
+}
+
+// encodeVariant encodes img with the encoder of the stream, at quality
+// unless it is 0.
+func (s *Stream) encodeVariant(img image.Image, quality int) ([]byte, error) {
+	s.encM.Lock()
+	c, opts := s.encoder, s.jpegOptions()
+	s.encM.Unlock()
+	if c == nil {
+		c = DefaultCodec
+	}
+	if quality > 0 {
+		opts.Quality = quality
+	}
+	var buf bytes.Buffer
+	if err := c.Encode(&buf, img, opts); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
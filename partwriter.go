@@ -0,0 +1,237 @@
+package mjpeg
+
+import (
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// partBufs holds the write buffers of the clients.
+var partBufs = sync.Pool{
+	New: func() any {
+		b := make([]byte, 0, 64<<10)
+		return &b
+	},
+}
+
+// maxPartBuf is the capacity above which a write buffer is not pooled.
+const maxPartBuf = 4 << 20
+
+// coalesceMax is the size up to which partWriter copies a frame to its
+// buffer instead of writing it apart.
+const coalesceMax = 16 << 10
+
+// partsWriter writes the parts of the frames of a ServeHTTP response.
+type partsWriter interface {
+	// writePart writes the part of f and returns the number of bytes of
+	// f.Data written.
+	writePart(f *Frame, now time.Time) (int, error)
+	// close writes the closing boundary.
+	close() error
+	// release frees the resources of the writer.
+	release()
+}
+
+// partWriter writes the parts of a multipart/x-mixed-replace response like
+// multipart.Writer, without allocating per part. The headers which do not
+// change are rendered once, the others are appended to a buffer taken from
+// partBufs. The data of the frames up to coalesceMax is copied after them
+// so that the part is a single Write, the larger frames are written from
+// the frame.
+type partWriter struct {
+	w        io.Writer
+	boundary string
+	format   TimestampFormat
+	start    time.Time
+	// static holds the rendered X-StartTime header.
+	static []byte
+	buf    *[]byte
+}
+
+func newPartWriter(w io.Writer, boundary string, format TimestampFormat, start time.Time) *partWriter {
+	pw := &partWriter{
+		w:        w,
+		boundary: boundary,
+		format:   format,
+		start:    start,
+		buf:      partBufs.Get().(*[]byte),
+	}
+	if format != TimestampNone {
+		pw.static = append(pw.static, "X-Starttime: "...)
+		pw.static = format.append(pw.static, start, start)
+		pw.static = append(pw.static, "\r\n"...)
+	}
+	return pw
+}
+
+// header renders the boundary and the headers of the part of f.
+func (pw *partWriter) header(f *Frame, now time.Time) []byte {
+	b := (*pw.buf)[:0]
+	b = append(b, "--"...)
+	b = append(b, pw.boundary...)
+	b = append(b, "\r\n"...)
+
+	for k, vs := range f.Header {
+		if reservedHeader(k) || !validHeaderName(k) {
+			continue
+		}
+		for _, v := range vs {
+			if validHeaderValue(v) {
+				b = appendHeader(b, k, v)
+			}
+		}
+	}
+	b = appendHeader(b, "Content-Type", f.ContentType())
+	b = append(b, "Content-Length: "...)
+	b = strconv.AppendInt(b, int64(len(f.Data)), 10)
+	b = append(b, "\r\n"...)
+	if f.Seq != 0 {
+		b = append(b, "X-Frame-Seq: "...)
+		b = strconv.AppendUint(b, f.Seq, 10)
+		b = append(b, "\r\n"...)
+	}
+	if pw.format != TimestampNone {
+		b = append(b, pw.static...)
+		b = append(b, "X-Timestamp: "...)
+		b = pw.format.append(b, now, pw.start)
+		b = append(b, "\r\n"...)
+	}
+	b = append(b, "\r\n"...)
+	*pw.buf = b
+	return b
+}
+
+// writePart writes the part of f and returns the number of bytes of f.Data
+// written. The CRLF which precedes the next boundary is written with the
+// part, so that the part is complete on the wire.
+func (pw *partWriter) writePart(f *Frame, now time.Time) (int, error) {
+	b := pw.header(f, now)
+	if len(f.Data) <= coalesceMax {
+		b = append(b, f.Data...)
+		b = append(b, "\r\n"...)
+		*pw.buf = b
+		n, err := pw.w.Write(b)
+		// Count only the body, like the writes to multipart.Writer did.
+		n -= len(b) - len(f.Data) - 2
+		return min(max(n, 0), len(f.Data)), err
+	}
+	if _, err := pw.w.Write(b); err != nil {
+		return 0, err
+	}
+	n, err := pw.w.Write(f.Data)
+	if err != nil {
+		return n, err
+	}
+	_, err = io.WriteString(pw.w, "\r\n")
+	return n, err
+}
+
+// close writes the closing boundary.
+func (pw *partWriter) close() error {
+	b := (*pw.buf)[:0]
+	b = append(b, "--"...)
+	b = append(b, pw.boundary...)
+	b = append(b, "--\r\n"...)
+	*pw.buf = b
+	_, err := pw.w.Write(b)
+	return err
+}
+
+// release returns the write buffer to partBufs.
+func (pw *partWriter) release() {
+	if pw.buf == nil {
+		return
+	}
+	if cap(*pw.buf) <= maxPartBuf {
+		partBufs.Put(pw.buf)
+	}
+	pw.buf = nil
+}
+
+func appendHeader(b []byte, k, v string) []byte {
+	b = append(b, k...)
+	b = append(b, ": "...)
+	b = append(b, v...)
+	return append(b, "\r\n"...)
+}
+
+// validHeaderName reports whether k is a token, RFC 9110 5.1.
+func validHeaderName(k string) bool {
+	if k == "" {
+		return false
+	}
+	for i := 0; i < len(k); i++ {
+		c := k[i]
+		if c <= ' ' || c >= 0x7F || strings.IndexByte(`"(),/:;<=>?@[\]{}`, c) >= 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// validHeaderValue reports whether v has no control character but tabs, so
+// that it can not end the header nor forge a boundary.
+func validHeaderValue(v string) bool {
+	for i := 0; i < len(v); i++ {
+		if c := v[i]; (c < ' ' && c != '\t') || c == 0x7F {
+			return false
+		}
+	}
+	return true
+}
+
+// reservedHeader reports whether the header k of a published frame is left
+// out, because the part writer sets it.
+func reservedHeader(k string) bool {
+	switch textproto.CanonicalMIMEHeaderKey(k) {
+	case "Content-Type", "Content-Length", "X-Frame-Seq", "X-Starttime", "X-Timestamp":
+		return true
+	}
+	return false
+}
+
+// multipartWriter writes the parts with multipart.Writer.
+type multipartWriter struct {
+	m      *multipart.Writer
+	format TimestampFormat
+	start  time.Time
+}
+
+func (mw *multipartWriter) writePart(f *Frame, now time.Time) (int, error) {
+	header := make(textproto.MIMEHeader, len(f.Header)+4)
+	for k, vs := range f.Header {
+		if !validHeaderName(k) {
+			continue
+		}
+		for _, v := range vs {
+			if validHeaderValue(v) {
+				header[k] = append(header[k], v)
+			}
+		}
+	}
+	header.Set("Content-Type", f.ContentType())
+	header.Set("Content-Length", fmt.Sprint(len(f.Data)))
+	if f.Seq != 0 {
+		header.Set("X-Frame-Seq", strconv.FormatUint(f.Seq, 10))
+	}
+	if mw.format != TimestampNone {
+		header.Set("X-StartTime", string(mw.format.append(nil, mw.start, mw.start)))
+		header.Set("X-TimeStamp", string(mw.format.append(nil, now, mw.start)))
+	}
+	w, err := mw.m.CreatePart(header)
+	if err != nil {
+		return 0, err
+	}
+	return w.Write(f.Data)
+}
+
+func (mw *multipartWriter) close() error {
+	return mw.m.Close()
+}
+
+func (mw *multipartWriter) release() {}
@@ -0,0 +1,85 @@
+package mjpeg
+
+import (
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+)
+
+func BenchmarkWritePart(b *testing.B) {
+	start := time.Now()
+	writers := []struct {
+		name string
+		new  func() partsWriter
+	}{
+		{"fast", func() partsWriter {
+			return newPartWriter(io.Discard, "frame", TimestampUnix, start)
+		}},
+		{"std", func() partsWriter {
+			return &multipartWriter{m: multipart.NewWriter(io.Discard), format: TimestampUnix, start: start}
+		}},
+	}
+	for _, size := range []int{4 << 10, 64 << 10} {
+		f := &Frame{
+			Data:   make([]byte, size),
+			Header: textproto.MIMEHeader{"X-Camera": {"front"}},
+			Seq:    1,
+		}
+		for _, w := range writers {
+			b.Run(fmt.Sprintf("%s/%dKiB", w.name, size>>10), func(b *testing.B) {
+				pw := w.new()
+				defer pw.release()
+				b.ReportAllocs()
+				b.SetBytes(int64(size))
+				for range b.N {
+					if _, err := pw.writePart(f, time.Now()); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestWritePartHeaderInjection(t *testing.T) {
+	f := &Frame{
+		Data: []byte("jpeg"),
+		Header: textproto.MIMEHeader{
+			"X-Camera":     {"front"},
+			"X-Evil":       {"a\r\nX-Injected: 1"},
+			"Bad\r\nKey":   {"b"},
+			"Content-Type": {"image/jpeg\r\n\r\n--frame"},
+		},
+	}
+	writers := map[string]func(io.Writer) partsWriter{
+		"fast": func(w io.Writer) partsWriter { return newPartWriter(w, "frame", TimestampNone, time.Now()) },
+		"std": func(w io.Writer) partsWriter {
+			m := multipart.NewWriter(w)
+			m.SetBoundary("frame")
+			return &multipartWriter{m: m, format: TimestampNone}
+		},
+	}
+	for name, newWriter := range writers {
+		t.Run(name, func(t *testing.T) {
+			var b strings.Builder
+			pw := newWriter(&b)
+			defer pw.release()
+			if _, err := pw.writePart(f, time.Now()); err != nil {
+				t.Fatal(err)
+			}
+			out := b.String()
+			if !strings.Contains(out, "X-Camera: front\r\n") {
+				t.Errorf("valid header missing:\n%s", out)
+			}
+			for _, s := range []string{"X-Injected", "X-Evil", "Key", "\r\n\r\n--frame"} {
+				if strings.Contains(out, s) {
+					t.Errorf("%q written:\n%s", s, out)
+				}
+			}
+		})
+	}
+}
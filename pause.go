@@ -0,0 +1,57 @@
+package mjpeg
+
+import (
+	"time"
+)
+
+// WithPausedFrame sets the frame sent to the clients when the stream is
+// paused. Without it, they keep the last frame before Pause.
+func WithPausedFrame(b []byte) StreamOption {
+	return func(s *Stream) {
+		s.pausedFrame = b
+	}
+}
+
+// Pause stops forwarding frames to the clients, without disconnecting them.
+// Update drops frames until Resume. The cached frame is forgotten so that
+// new clients and snapshots do not get a frame from before the pause.
+func (s *Stream) Pause() {
+	s.pubM.Lock()
+	defer s.pubM.Unlock()
+	s.m.Lock()
+	if s.paused || s.closed {
+		s.m.Unlock()
+		return
+	}
+	s.paused = true
+	s.last.release()
+	s.last = nil
+	s.lastHash = 0
+	s.coalesced.release()
+	s.coalesced = nil
+	if s.pausedFrame == nil {
+		s.m.Unlock()
+		return
+	}
+	f := &Frame{Data: s.pausedFrame, Time: time.Now()}
+	s.last = f
+	snap := s.snapshot()
+	s.m.Unlock()
+
+	fanout(snap, f)
+}
+
+// Resume forwards frames again after Pause.
+func (s *Stream) Resume() {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.paused = false
+	s.updated = time.Now()
+}
+
+// Paused reports whether the stream is paused.
+func (s *Stream) Paused() bool {
+	s.m.Lock()
+	defer s.m.Unlock()
+	return s.paused
+}
@@ -0,0 +1,75 @@
+package mjpeg
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"time"
+)
+
+// WithPlaceholder makes the Stream serve b when no Update arrived for stale,
+// so that viewers show an outage instead of a frozen frame. When b is nil, a
+// "NO SIGNAL" frame with the current time is generated, at the size of the
+// last frame.
+func WithPlaceholder(stale time.Duration, b []byte) StreamOption {
+	return func(s *Stream) {
+		s.staleAfter = stale
+		s.noSignal = b
+	}
+}
+
+// stale reports whether no frame was published within the placeholder window.
+func (s *Stream) stale(now time.Time) bool {
+	s.m.Lock()
+	defer s.m.Unlock()
+	return now.Sub(s.updated) >= s.staleAfter
+}
+
+// placeholder returns the frame served while the source is down. Generated
+// frames are kept for the second of their timestamp.
+func (s *Stream) placeholder(now time.Time) *Frame {
+	if s.noSignal != nil {
+		return &Frame{Data: s.noSignal, Time: now}
+	}
+
+	s.phM.Lock()
+	defer s.phM.Unlock()
+	if s.ph != nil && s.ph.Time.Unix() == now.Unix() {
+		return s.ph
+	}
+	w, h := s.frameSize()
+	var buf bytes.Buffer
+	if err := DefaultCodec.Encode(&buf, textImage(w, h, "NO SIGNAL", now), nil); err != nil {
+		return nil
+	}
+	s.ph = &Frame{Data: buf.Bytes(), Time: now}
+	return s.ph
+}
+
+// frameSize returns the size of the last frame, 640x480 when unknown.
+func (s *Stream) frameSize() (int, int) {
+	w, h := 640, 480
+	if b, ok := s.TryCurrent(); ok {
+		if cfg, err := decodeImageConfig(DefaultCodec, b); err == nil {
+			w, h = cfg.Width, cfg.Height
+		}
+	}
+	return w, h
+}
+
+// textImage draws title and t in the middle of a w x h gray image.
+func textImage(w, h int, title string, t time.Time) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(img, img.Bounds(), image.NewUniform(color.Gray{Y: 0x30}), image.Point{}, draw.Src)
+
+	stamp := t.Format("2006-01-02 15:04:05")
+	scale := max(1, w/2/textWidth(title, 1))
+	small := max(1, scale/3)
+	height := glyphHeight*scale + glyphHeight*small*2
+	y := (h - height) / 2
+	drawText(img, image.Pt((w-textWidth(title, scale))/2, y), scale, title, color.White)
+	y += glyphHeight*scale + glyphHeight*small
+	drawText(img, image.Pt((w-textWidth(stamp, small))/2, y), small, stamp, color.Gray{Y: 0xC0})
+	return img
+}
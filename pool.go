@@ -0,0 +1,48 @@
+package mjpeg
+
+import (
+	"io"
+	"sync"
+)
+
+var partPool = sync.Pool{
+	New: func() interface{} {
+		b := make([]byte, 0, 64*1024)
+		return &b
+	},
+}
+
+// newPart returns a part whose data buffer comes from partPool.
+func newPart() *part {
+	bp := partPool.Get().(*[]byte)
+	return &part{data: (*bp)[:0], pooled: bp}
+}
+
+// release returns the data buffer to the pool. The part must not be used
+// afterwards. Parts whose data is handed to the caller are never released.
+func (p *part) release() {
+	if p.pooled == nil {
+		return
+	}
+	*p.pooled = p.data[:0]
+	partPool.Put(p.pooled)
+	p.pooled = nil
+	p.data = nil
+}
+
+// readAll appends everything read from r to b.
+func readAll(b []byte, r io.Reader) ([]byte, error) {
+	for {
+		if len(b) == cap(b) {
+			b = append(b, 0)[:len(b)]
+		}
+		n, err := r.Read(b[len(b):cap(b)])
+		b = b[:len(b)+n]
+		if err != nil {
+			if err == io.EOF {
+				err = nil
+			}
+			return b, err
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package mjpeg
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrPoolClosed is returned when using a closed DecoderPool.
+var ErrPoolClosed = errors.New("mjpeg: decoder pool closed")
+
+// CameraFrame is a frame delivered by DecoderPool tagged with its camera
+type CameraFrame struct {
+	Camera string
+	*Frame
+	// Err is set instead of Frame when the camera gave up, e.g. after
+	// WithMaxRetries attempts. The camera is removed from the pool then.
+	Err error
+}
+
+// DecoderPool manages reconnecting decoders of several cameras and delivers
+// their frames on a single channel
+type DecoderPool struct {
+	opts   []DecoderOption
+	frames chan CameraFrame
+	done   chan struct{}
+
+	m       sync.Mutex
+	cameras map[string]*Decoder
+	closed  bool
+	wg      sync.WaitGroup
+}
+
+// NewDecoderPool return new instance of DecoderPool. opts apply to every camera.
+func NewDecoderPool(opts ...DecoderOption) *DecoderPool {
+	return &DecoderPool{
+		opts:    opts,
+		frames:  make(chan CameraFrame),
+		done:    make(chan struct{}),
+		cameras: make(map[string]*Decoder),
+	}
+}
+
+// Add start decoding the camera at URL u as id. opts are applied after the
+// options of the pool.
+func (p *DecoderPool) Add(id, u string, opts ...DecoderOption) error {
+	all := append(append([]DecoderOption(nil), p.opts...), opts...)
+	d, err := NewReconnectingDecoder(u, all...)
+	if err != nil {
+		return err
+	}
+
+	p.m.Lock()
+	defer p.m.Unlock()
+	if p.closed {
+		return ErrPoolClosed
+	}
+	if _, ok := p.cameras[id]; ok {
+		return errors.New("mjpeg: camera " + id + " already in pool")
+	}
+	p.cameras[id] = d
+	p.wg.Add(1)
+	go p.run(id, d)
+	return nil
+}
+
+// Remove stop decoding the camera id and close its connection.
+func (p *DecoderPool) Remove(id string) error {
+	p.m.Lock()
+	d, ok := p.cameras[id]
+	delete(p.cameras, id)
+	p.m.Unlock()
+	if !ok {
+		return errors.New("mjpeg: camera " + id + " not in pool")
+	}
+	return d.Close()
+}
+
+// Cameras return the ids of the cameras in the pool.
+func (p *DecoderPool) Cameras() []string {
+	p.m.Lock()
+	defer p.m.Unlock()
+	ids := make([]string, 0, len(p.cameras))
+	for id := range p.cameras {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// Decoder return the decoder of the camera id, e.g. to read its Stats.
+func (p *DecoderPool) Decoder(id string) (*Decoder, bool) {
+	p.m.Lock()
+	defer p.m.Unlock()
+	d, ok := p.cameras[id]
+	return d, ok
+}
+
+// Frames return the channel the frames of all cameras are delivered on. It is
+// closed by Close.
+func (p *DecoderPool) Frames() <-chan CameraFrame {
+	return p.frames
+}
+
+// Close stop all cameras and wait for them to finish.
+func (p *DecoderPool) Close() error {
+	p.m.Lock()
+	if p.closed {
+		p.m.Unlock()
+		return nil
+	}
+	p.closed = true
+	close(p.done)
+	for id, d := range p.cameras {
+		d.Close()
+		delete(p.cameras, id)
+	}
+	p.m.Unlock()
+
+	p.wg.Wait()
+	close(p.frames)
+	return nil
+}
+
+func (p *DecoderPool) run(id string, d *Decoder) {
+	defer p.wg.Done()
+	for {
+		f, err := d.DecodeFrame()
+		if err == ErrDecoderClosed {
+			return
+		}
+		if err == ErrStalled {
+			// The decoder connects again on the next call.
+			continue
+		}
+		cf := CameraFrame{Camera: id, Frame: f}
+		if err != nil {
+			cf = CameraFrame{Camera: id, Err: err}
+			p.m.Lock()
+			if p.cameras[id] == d {
+				delete(p.cameras, id)
+			}
+			p.m.Unlock()
+			d.Close()
+		}
+		select {
+		case p.frames <- cf:
+		case <-p.done:
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package mjpeg
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net/textproto"
+)
+
+// WithRawJPEG make the decoder read back-to-back JPEG images which have no
+// multipart framing, splitting frames at SOI/EOI markers. NewDecoderFromResponse
+// selects this mode by itself for image/jpeg responses and for bodies which start
+// with a JPEG SOI marker instead of a boundary.
+func WithRawJPEG() DecoderOption {
+	return func(o *decoderOptions) {
+		o.raw = true
+	}
+}
+
+type rawSource struct {
+	br    *bufio.Reader
+	limit int
+}
+
+func newRawSource(r io.Reader, limit int) *rawSource {
+	br, ok := r.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	return &rawSource{br: br, limit: limit}
+}
+
+// startsWithSOI reports whether the stream in br begins with a JPEG SOI marker.
+func startsWithSOI(br *bufio.Reader) bool {
+	b, err := br.Peek(2)
+	return err == nil && b[0] == 0xFF && b[1] == 0xD8
+}
+
+func (s *rawSource) next() (*part, error) {
+	p := newPart()
+	data, err := s.readImage(p.data)
+	if err != nil {
+		p.release()
+		return nil, err
+	}
+	p.data = data
+	p.header = textproto.MIMEHeader{}
+	p.header.Set("Content-Type", "image/jpeg")
+	return p, nil
+}
+
+func (s *rawSource) skip() error {
+	p, err := s.next()
+	if err != nil {
+		return err
+	}
+	p.release()
+	return nil
+}
+
+// readImage appends the next whole JPEG image in the stream to data.
+func (s *rawSource) readImage(data []byte) ([]byte, error) {
+	// Skip garbage up to the start of the image.
+	for {
+		b, err := s.br.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if b != 0xFF {
+			continue
+		}
+		c, err := s.br.ReadByte()
+		if err != nil {
+			return nil, noEOF(err)
+		}
+		if c == 0xD8 {
+			break
+		}
+		if c == 0xFF {
+			s.br.UnreadByte()
+		}
+	}
+
+	data = append(data, 0xFF, 0xD8)
+	for {
+		b, err := s.br.ReadByte()
+		if err != nil {
+			return nil, noEOF(err)
+		}
+		if b != 0xFF {
+			return nil, errMalformedPart
+		}
+		code := byte(0xFF)
+		for code == 0xFF {
+			if code, err = s.br.ReadByte(); err != nil {
+				return nil, noEOF(err)
+			}
+		}
+		data = append(data, 0xFF, code)
+
+		switch {
+		case code == 0xD9:
+			return data, nil
+		case code == 0x01 || (code >= 0xD0 && code <= 0xD7):
+			continue
+		}
+
+		var l [2]byte
+		if _, err := io.ReadFull(s.br, l[:]); err != nil {
+			return nil, noEOF(err)
+		}
+		n := int(l[0])<<8 | int(l[1])
+		if n < 2 {
+			return nil, errMalformedPart
+		}
+		if exceeds(len(data)+n, s.limit) {
+			return nil, &FrameSizeError{Limit: s.limit}
+		}
+		data = append(data, l[:]...)
+		start := len(data)
+		data = append(data, make([]byte, n-2)...)
+		if _, err := io.ReadFull(s.br, data[start:]); err != nil {
+			return nil, noEOF(err)
+		}
+
+		if code == 0xDA {
+			if data, err = s.scanEntropy(data); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+// scanEntropy appends entropy coded data following SOS up to the next marker.
+func (s *rawSource) scanEntropy(data []byte) ([]byte, error) {
+	for {
+		if _, err := s.br.Peek(1); err != nil {
+			return nil, noEOF(err)
+		}
+		buf, _ := s.br.Peek(s.br.Buffered())
+		i := bytes.IndexByte(buf, 0xFF)
+		if i < 0 {
+			data = append(data, buf...)
+			s.br.Discard(len(buf))
+			if exceeds(len(data), s.limit) {
+				return nil, &FrameSizeError{Limit: s.limit}
+			}
+			continue
+		}
+		data = append(data, buf[:i]...)
+		s.br.Discard(i)
+
+		two, err := s.br.Peek(2)
+		if err != nil {
+			return nil, noEOF(err)
+		}
+		if two[1] == 0x00 || (two[1] >= 0xD0 && two[1] <= 0xD7) {
+			data = append(data, two...)
+			s.br.Discard(2)
+			continue
+		}
+		return data, nil
+	}
+}
+
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,129 @@
+package mjpeg
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// ErrMaxRetries is returned when a reconnecting decoder gives up after the configured number of attempts.
+var ErrMaxRetries = errors.New("mjpeg: max reconnect retries exceeded")
+
+// ConnState is a state of the connection to the camera
+type ConnState int
+
+const (
+	// Connecting is reported before every connection attempt.
+	Connecting ConnState = iota
+	// Connected is reported when the camera responded with a stream.
+	Connected
+	// Disconnected is reported when a connection failed or the stream broke,
+	// Backoff in ConnStatus tells how long it waits before the next attempt.
+	Disconnected
+)
+
+func (s ConnState) String() string {
+	switch s {
+	case Connecting:
+		return "connecting"
+	case Connected:
+		return "connected"
+	case Disconnected:
+		return "disconnected"
+	}
+	return fmt.Sprintf("ConnState(%d)", int(s))
+}
+
+// ConnStatus is passed to the callback set by WithStatusFunc
+type ConnStatus struct {
+	State   ConnState
+	Attempt int
+	Backoff time.Duration
+	Err     error
+}
+
+// NewReconnectingDecoder return new instance of Decoder which connects to the
+// specified URL lazily, and connects again with exponential backoff when the
+// connection fails or the stream ends.
+func NewReconnectingDecoder(u string, opts ...DecoderOption) (*Decoder, error) {
+	if _, err := http.NewRequest("GET", u, nil); err != nil {
+		return nil, err
+	}
+	d := &Decoder{opts: newDecoderOptions(opts), done: make(chan struct{})}
+	// The client is built once, a Transport per attempt would leave the
+	// connections of the previous ones open.
+	opts = append(opts[:len(opts):len(opts)], withResolvedClient(d.opts.httpClient()))
+	d.connect = func() (*Decoder, error) {
+		return NewDecoderFromURL(u, opts...)
+	}
+	return d, nil
+}
+
+// Connected reports whether the decoder is connected to its source. A
+// decoder which does not reconnect is connected until it is closed.
+func (d *Decoder) Connected() bool {
+	select {
+	case <-d.done:
+		return false
+	default:
+	}
+	if d.connect == nil {
+		return true
+	}
+	return d.connected.Load()
+}
+
+func (d *Decoder) status(st ConnStatus) {
+	d.connected.Store(st.State == Connected)
+	if d.opts.onStatus != nil {
+		d.opts.onStatus(st)
+	}
+}
+
+// reconnect replaces the underlying stream with a new connection. cause is the
+// error which broke the previous stream, nil if there was none yet.
+func (d *Decoder) reconnect(cause error) error {
+	d.dropConnection()
+
+	backoff := d.opts.minBackoff
+	for attempt := 1; ; attempt++ {
+		if cause != nil {
+			if d.opts.maxRetries > 0 && attempt > d.opts.maxRetries {
+				return fmt.Errorf("%w: %v", ErrMaxRetries, cause)
+			}
+			d.status(ConnStatus{State: Disconnected, Attempt: attempt, Backoff: backoff, Err: cause})
+			select {
+			case <-time.After(backoff):
+			case <-d.done:
+				return ErrDecoderClosed
+			}
+			backoff *= 2
+			if backoff > d.opts.maxBackoff {
+				backoff = d.opts.maxBackoff
+			}
+		}
+
+		d.status(ConnStatus{State: Connecting, Attempt: attempt})
+		nd, err := d.connect()
+		if err != nil {
+			cause = err
+			continue
+		}
+		d.bm.Lock()
+		select {
+		case <-d.done:
+			d.bm.Unlock()
+			nd.Close()
+			return ErrDecoderClosed
+		default:
+		}
+		d.src, d.body = nd.src, nd.body
+		d.bm.Unlock()
+		if cause != nil {
+			d.stats.reconnects.Add(1)
+		}
+		d.status(ConnStatus{State: Connected, Attempt: attempt})
+		return nil
+	}
+}
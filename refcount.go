@@ -0,0 +1,72 @@
+package mjpeg
+
+import (
+	"bytes"
+	"time"
+)
+
+// FrameBuffer is a buffer from the pool of a Stream. It is filled by the
+// publisher and handed over to UpdateBuffer, which returns it to the pool
+// once the last viewer wrote it, so that publishing does not allocate nor
+// copy per viewer.
+type FrameBuffer struct {
+	// Data is empty with the capacity of the pooled buffer, the frame is
+	// appended to it.
+	Data []byte
+
+	p *[]byte
+}
+
+// Buffer returns a FrameBuffer for UpdateBuffer.
+func (s *Stream) Buffer() *FrameBuffer {
+	p, _ := s.bufs.Get().(*[]byte)
+	if p == nil {
+		b := make([]byte, 0, 64*1024)
+		p = &b
+	}
+	return &FrameBuffer{Data: (*p)[:0], p: p}
+}
+
+// UpdateBuffer is like Update for a frame in a FrameBuffer. fb must not be
+// used after the call. Viewers of ServeHTTP share the buffer, subscriptions
+// and Current get a copy since the stream can not tell when they are done.
+func (s *Stream) UpdateBuffer(fb *FrameBuffer) error {
+	f := &Frame{Data: fb.Data, Time: time.Now(), buf: fb, bufs: &s.bufs}
+	f.refs.Store(1)
+	err := s.publish(f)
+	f.release()
+	return err
+}
+
+// retain adds a reference to a pooled frame.
+func (f *Frame) retain() {
+	if f != nil && f.buf != nil {
+		f.refs.Add(1)
+	}
+}
+
+// release drops a reference, the last one returns the buffer to the pool.
+func (f *Frame) release() {
+	if f == nil || f.buf == nil {
+		return
+	}
+	if f.refs.Add(-1) == 0 {
+		*f.buf.p = f.Data[:0]
+		f.bufs.Put(f.buf.p)
+	}
+}
+
+// detached returns f, or a copy of it when it is pooled, for consumers which
+// keep it out of the reference counting.
+func (f *Frame) detached() *Frame {
+	if f == nil || f.buf == nil {
+		return f
+	}
+	return &Frame{
+		Data:        bytes.Clone(f.Data),
+		Header:      f.Header,
+		Seq:         f.Seq,
+		Time:        f.Time,
+		contentType: f.contentType,
+	}
+}
@@ -0,0 +1,253 @@
+package mjpeg
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/textproto"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// RelayOption is an option of NewRelay
+type RelayOption func(*relayOptions)
+
+type relayOptions struct {
+	policy   DropPolicy
+	interval time.Duration
+	onError  func(error)
+
+	transformers []FrameTransformer
+	headers      []string
+
+	decoderOpts []DecoderOption
+	outage      bool
+	outageFrame []byte
+}
+
+// WithRelayPolicy sets what the relay does with the frames read while the
+// stream is still publishing the previous one. DropOldest, the default,
+// publishes the latest frame next. DropNewest publishes the frame being
+// waited for and discards those read meanwhile. Block reads the camera no
+// faster than the stream publishes.
+func WithRelayPolicy(p DropPolicy) RelayOption {
+	return func(o *relayOptions) {
+		o.policy = p
+	}
+}
+
+// WithRelayMaxFPS drops the frames read less than 1/fps after the last frame
+// published.
+func WithRelayMaxFPS(fps float64) RelayOption {
+	return func(o *relayOptions) {
+		if fps > 0 {
+			o.interval = time.Duration(float64(time.Second) / fps)
+		}
+	}
+}
+
+// WithRelayErrorFunc sets f to be called with the frames refused by the
+// stream, such as an InvalidFrameError of WithUpdateValidation. The relay
+// goes on with the next frame.
+func WithRelayErrorFunc(f func(error)) RelayOption {
+	return func(o *relayOptions) {
+		o.onError = f
+	}
+}
+
+// WithRelayHeaders forwards the part headers of the camera named by keys
+// with the frames, none are by default. The content type of the frames is
+// kept without it.
+func WithRelayHeaders(keys ...string) RelayOption {
+	return func(o *relayOptions) {
+		o.headers = append(o.headers, keys...)
+	}
+}
+
+// RelayStats is a snapshot of Relay counters
+type RelayStats struct {
+	// Read is the number of frames read from the decoder.
+	Read uint64
+	// Published is the number of frames accepted by the stream.
+	Published uint64
+	// Dropped is the number of frames discarded by the policy or the rate.
+	Dropped uint64
+	// Errors is the number of frames refused by the stream.
+	Errors uint64
+}
+
+// Relay publishes the frames of a Decoder to a Stream, without decoding them.
+type Relay struct {
+	d    *Decoder
+	s    *Stream
+	opts relayOptions
+	// sup is set by NewSupervisedRelay.
+	sup *supervision
+
+	read      atomic.Uint64
+	published atomic.Uint64
+	dropped   atomic.Uint64
+	errors    atomic.Uint64
+	last      time.Time
+}
+
+// NewRelay return new instance of Relay from d to s, started by Run.
+func NewRelay(d *Decoder, s *Stream, opts ...RelayOption) *Relay {
+	r := &Relay{d: d, s: s, opts: relayOptions{policy: DropOldest}}
+	for _, opt := range opts {
+		opt(&r.opts)
+	}
+	return r
+}
+
+// Stats return counters of the relay
+func (r *Relay) Stats() RelayStats {
+	return RelayStats{
+		Read:      r.read.Load(),
+		Published: r.published.Load(),
+		Dropped:   r.dropped.Load(),
+		Errors:    r.errors.Load(),
+	}
+}
+
+// Run relays the frames until ctx is done, the stream is closed or the
+// decoder fails. It returns nil when ctx is done, the stream is closed or the
+// camera ended the stream, the error of the decoder otherwise. The decoder of
+// NewRelay is left open, a reconnecting decoder only fails when it gives up.
+func (r *Relay) Run(ctx context.Context) error {
+	if r.sup != nil {
+		return r.runSupervised(ctx)
+	}
+	return r.run(ctx)
+}
+
+func (r *Relay) run(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	if r.opts.policy == Block {
+		for {
+			f, err := r.d.decodeFrame(ctx)
+			if err != nil {
+				return r.readErr(ctx, err)
+			}
+			r.read.Add(1)
+			if !r.publish(f) {
+				return nil
+			}
+		}
+	}
+
+	// The frames are read in background, next holds one until published.
+	var (
+		m    sync.Mutex
+		next *Frame
+		err  error
+	)
+	ready := make(chan struct{}, 1)
+	exited := make(chan struct{})
+	defer func() {
+		cancel()
+		<-exited
+	}()
+	go func() {
+		defer close(exited)
+		for {
+			f, rerr := r.d.decodeFrame(ctx)
+			m.Lock()
+			if rerr != nil {
+				err = rerr
+			} else {
+				r.read.Add(1)
+				switch {
+				case next == nil:
+					next = f
+				case r.opts.policy == DropOldest:
+					next = f
+					r.dropped.Add(1)
+				default:
+					r.dropped.Add(1)
+				}
+			}
+			m.Unlock()
+			select {
+			case ready <- struct{}{}:
+			default:
+			}
+			if rerr != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case <-ready:
+		case <-ctx.Done():
+			return nil
+		}
+		m.Lock()
+		f, rerr := next, err
+		next = nil
+		m.Unlock()
+		if f != nil && !r.publish(f) {
+			return nil
+		}
+		if rerr != nil {
+			return r.readErr(ctx, rerr)
+		}
+	}
+}
+
+// publish sends f to the stream, it returns false once the stream is closed.
+// header returns the headers of f forwarded by WithRelayHeaders.
+func (r *Relay) header(f *Frame) textproto.MIMEHeader {
+	var h textproto.MIMEHeader
+	for _, k := range r.opts.headers {
+		if vs := f.Header.Values(k); len(vs) > 0 {
+			if h == nil {
+				h = textproto.MIMEHeader{}
+			}
+			h[textproto.CanonicalMIMEHeaderKey(k)] = vs
+		}
+	}
+	return h
+}
+
+func (r *Relay) publish(f *Frame) bool {
+	if r.opts.interval > 0 && f.Time.Sub(r.last) < r.opts.interval {
+		r.dropped.Add(1)
+		return true
+	}
+	var err error
+	if len(r.opts.transformers) > 0 {
+		f, err = pipeline{s: r.s, ts: r.opts.transformers}.run(f)
+	}
+	if f == nil && err == nil {
+		r.dropped.Add(1)
+		return true
+	}
+	if err == nil {
+		err = r.s.publish(&Frame{Data: f.Data, Header: r.header(f), Time: f.Time, contentType: f.ContentType()})
+	}
+	switch {
+	case err == nil:
+		r.published.Add(1)
+		r.last = f.Time
+	case errors.Is(err, ErrStreamClosed):
+		return false
+	default:
+		r.errors.Add(1)
+		if r.opts.onError != nil {
+			r.opts.onError(err)
+		}
+	}
+	return true
+}
+
+func (r *Relay) readErr(ctx context.Context, err error) error {
+	if ctx.Err() != nil || errors.Is(err, io.EOF) || errors.Is(err, ErrDecoderClosed) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,42 @@
+package mjpeg
+
+import (
+	"net/textproto"
+	"testing"
+)
+
+func TestRelayHeaders(t *testing.T) {
+	f := &Frame{
+		Data: gradientJPEG(t, 16, 16),
+		Header: textproto.MIMEHeader{
+			"Content-Type":   {"image/jpeg"},
+			"Content-Length": {"12"},
+			"X-Camera":       {"front"},
+			"X-Secret":       {"token"},
+		},
+	}
+	tests := []struct {
+		name string
+		opts []RelayOption
+		want textproto.MIMEHeader
+	}{
+		{"default", nil, nil},
+		{"allowed", []RelayOption{WithRelayHeaders("x-camera")}, textproto.MIMEHeader{"X-Camera": {"front"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewStream()
+			defer s.Close()
+			if !NewRelay(nil, s, tt.opts...).publish(f) {
+				t.Fatal("stream closed")
+			}
+			got := s.last.Header
+			if len(got) != len(tt.want) || got.Get("X-Camera") != tt.want.Get("X-Camera") {
+				t.Errorf("header %v, want %v", got, tt.want)
+			}
+			if ct := s.last.ContentType(); ct != "image/jpeg" {
+				t.Errorf("content type %q", ct)
+			}
+		})
+	}
+}
@@ -0,0 +1,314 @@
+package rtsp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+)
+
+// errNotRFC2435 is returned by parseJPEG for a JPEG which RFC 2435 cannot
+// carry as is, a re-encoded one can.
+var errNotRFC2435 = errors.New("rtsp: JPEG not supported by RFC 2435")
+
+// maxPayload is the size of the largest RTP payload, so that the packets fit
+// in the MTU of the usual networks.
+const maxPayload = 1400 - rtpHeaderSize
+
+// jpegFrame is a JPEG cut into RTP payloads, RFC 2435.
+type jpegFrame struct {
+	payloads [][]byte
+}
+
+// component is a component of the SOF, with the tables of the SOS.
+type component struct {
+	id, h, v, tq, td, ta byte
+}
+
+// parseJPEG cuts b, a baseline YCbCr 4:2:2 or 4:2:0 JPEG with the Huffman
+// tables of the standard, in RTP payloads.
+func parseJPEG(b []byte) (*jpegFrame, error) {
+	if len(b) < 4 || b[0] != 0xFF || b[1] != 0xD8 {
+		return nil, errors.New("rtsp: frame is not a JPEG")
+	}
+	var (
+		qt               [4][]byte
+		dht              [2][4][]byte
+		comps            []component
+		width, height    int
+		restart          uint16
+		scan             []byte
+		haveSOF, haveSOS bool
+	)
+	for i := 2; !haveSOS; {
+		if i+4 > len(b) || b[i] != 0xFF {
+			return nil, errors.New("rtsp: truncated JPEG")
+		}
+		m := b[i+1]
+		if m == 0xFF {
+			i++
+			continue
+		}
+		if m == 0x01 || m >= 0xD0 && m <= 0xD8 {
+			i += 2
+			continue
+		}
+		n := int(binary.BigEndian.Uint16(b[i+2:]))
+		if n < 2 || i+2+n > len(b) {
+			return nil, errors.New("rtsp: truncated JPEG")
+		}
+		seg := b[i+4 : i+2+n]
+		switch {
+		case m == 0xDB:
+			for len(seg) > 0 {
+				if seg[0]>>4 != 0 {
+					return nil, fmt.Errorf("%w: 16-bit quantization table", errNotRFC2435)
+				}
+				if seg[0]&0x0F > 3 || len(seg) < 65 {
+					return nil, errors.New("rtsp: invalid DQT")
+				}
+				qt[seg[0]&0x0F] = seg[1:65]
+				seg = seg[65:]
+			}
+		case m == 0xC4:
+			for len(seg) > 0 {
+				if len(seg) < 17 || seg[0]>>4 > 1 || seg[0]&0x0F > 3 {
+					return nil, errors.New("rtsp: invalid DHT")
+				}
+				n := 17
+				for _, c := range seg[1:17] {
+					n += int(c)
+				}
+				if len(seg) < n {
+					return nil, errors.New("rtsp: invalid DHT")
+				}
+				dht[seg[0]>>4][seg[0]&0x0F] = seg[1:n]
+				seg = seg[n:]
+			}
+		case m == 0xC0:
+			if len(seg) < 6 || len(seg) < 6+3*int(seg[5]) {
+				return nil, errors.New("rtsp: invalid SOF")
+			}
+			if seg[0] != 8 {
+				return nil, fmt.Errorf("%w: %d-bit samples", errNotRFC2435, seg[0])
+			}
+			height = int(binary.BigEndian.Uint16(seg[1:]))
+			width = int(binary.BigEndian.Uint16(seg[3:]))
+			for c := seg[6 : 6+3*int(seg[5])]; len(c) > 0; c = c[3:] {
+				comps = append(comps, component{id: c[0], h: c[1] >> 4, v: c[1] & 0x0F, tq: c[2] & 0x03})
+			}
+			haveSOF = true
+		case m >= 0xC1 && m <= 0xCF && m != 0xC8 && m != 0xCC:
+			return nil, fmt.Errorf("%w: not baseline", errNotRFC2435)
+		case m == 0xDD:
+			if len(seg) < 2 {
+				return nil, errors.New("rtsp: invalid DRI")
+			}
+			restart = binary.BigEndian.Uint16(seg)
+		case m == 0xDA:
+			if !haveSOF {
+				return nil, errors.New("rtsp: SOS before SOF")
+			}
+			if len(seg) < 1 || len(seg) < 1+2*int(seg[0]) {
+				return nil, errors.New("rtsp: invalid SOS")
+			}
+			if int(seg[0]) != len(comps) {
+				return nil, fmt.Errorf("%w: several scans", errNotRFC2435)
+			}
+			for k := range comps {
+				c := seg[1+2*k:]
+				if c[0] != comps[k].id {
+					return nil, fmt.Errorf("%w: several scans", errNotRFC2435)
+				}
+				comps[k].td, comps[k].ta = c[1]>>4&0x03, c[1]&0x03
+			}
+			scan = b[i+2+n:]
+			haveSOS = true
+		case m == 0xD9:
+			return nil, errors.New("rtsp: JPEG without scan")
+		}
+		i += 2 + n
+	}
+
+	// The receivers rebuild the headers from the type, with a luminance
+	// component sampled 2x1 or 2x2 and two chrominance components sharing
+	// a table.
+	if len(comps) != 3 {
+		return nil, fmt.Errorf("%w: %d components", errNotRFC2435, len(comps))
+	}
+	var typ byte
+	switch y := comps[0]; {
+	case y.h == 2 && y.v == 1:
+		typ = 0
+	case y.h == 2 && y.v == 2:
+		typ = 1
+	default:
+		return nil, fmt.Errorf("%w: %dx%d sampling", errNotRFC2435, y.h, y.v)
+	}
+	for _, c := range comps[1:] {
+		if c.h != 1 || c.v != 1 || c.tq != comps[1].tq {
+			return nil, fmt.Errorf("%w: chrominance sampling", errNotRFC2435)
+		}
+	}
+	luma, chroma := qt[comps[0].tq], qt[comps[1].tq]
+	if luma == nil || chroma == nil {
+		return nil, errors.New("rtsp: missing quantization table")
+	}
+	// A JPEG without DHT, like those of many cameras, uses the standard
+	// tables.
+	for k, c := range comps {
+		std := 0
+		if k > 0 {
+			std = 1
+		}
+		if t := dht[0][c.td]; t != nil && !bytes.Equal(t, stdHuffman[std][0]) {
+			return nil, fmt.Errorf("%w: Huffman tables", errNotRFC2435)
+		}
+		if t := dht[1][c.ta]; t != nil && !bytes.Equal(t, stdHuffman[std][1]) {
+			return nil, fmt.Errorf("%w: Huffman tables", errNotRFC2435)
+		}
+	}
+	if width == 0 || height == 0 || width > 2040 || height > 2040 {
+		return nil, fmt.Errorf("rtsp: %dx%d frame, RFC 2435 sends up to 2040x2040", width, height)
+	}
+	if k := bytes.LastIndex(scan, []byte{0xFF, 0xD9}); k >= 0 {
+		scan = scan[:k]
+	}
+	if len(scan) == 0 {
+		return nil, errors.New("rtsp: empty scan")
+	}
+	if len(scan) >= 1<<24 {
+		return nil, errors.New("rtsp: frame larger than 16MB")
+	}
+
+	// Main header, then the restart header and, in the first packet, the
+	// quantization tables, Q being 255.
+	header := []byte{0, 0, 0, 0, typ, 255, byte((width + 7) / 8), byte((height + 7) / 8)}
+	if restart > 0 {
+		header[4] += 64
+		// The count is 0x3FFF for a frame which is decoded once whole.
+		header = binary.BigEndian.AppendUint16(header, restart)
+		header = append(header, 0xFF, 0xFF)
+	}
+	j := &jpegFrame{}
+	buf := make([]byte, 0, len(scan)+(len(scan)/maxPayload+1)*len(header)+4+128)
+	for off := 0; off == 0 || off < len(scan); {
+		start := len(buf)
+		buf = append(buf, header...)
+		buf[start+1], buf[start+2], buf[start+3] = byte(off>>16), byte(off>>8), byte(off)
+		if off == 0 {
+			buf = append(buf, 0, 0, 0, 128)
+			buf = append(buf, luma...)
+			buf = append(buf, chroma...)
+		}
+		n := min(len(scan)-off, maxPayload-(len(buf)-start))
+		buf = append(buf, scan[off:off+n]...)
+		j.payloads = append(j.payloads, buf[start:])
+		off += n
+	}
+	return j, nil
+}
+
+// packJPEG cuts b in RTP payloads, after re-encoding it with quality when
+// RFC 2435 cannot carry it.
+func packJPEG(b []byte, quality int) (*jpegFrame, error) {
+	j, err := parseJPEG(b)
+	if !errors.Is(err, errNotRFC2435) {
+		return j, err
+	}
+	// image/jpeg encodes color images as 4:2:0 with the standard tables.
+	img, err := jpeg.Decode(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	if g, ok := img.(*image.Gray); ok {
+		img = grayToYCbCr(g)
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}); err != nil {
+		return nil, err
+	}
+	return parseJPEG(buf.Bytes())
+}
+
+// grayToYCbCr returns g as a color image, image/jpeg encodes a gray one with
+// a single component.
+func grayToYCbCr(g *image.Gray) *image.YCbCr {
+	r := g.Bounds()
+	img := image.NewYCbCr(r, image.YCbCrSubsampleRatio420)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		copy(img.Y[img.YOffset(r.Min.X, y):], g.Pix[g.PixOffset(r.Min.X, y):g.PixOffset(r.Max.X, y)])
+	}
+	for i := range img.Cb {
+		img.Cb[i], img.Cr[i] = 128, 128
+	}
+	return img
+}
+
+// stdHuffman are the DC and AC Huffman tables of the luminance and the
+// chrominance of section K.3 of the JPEG standard, which the receivers of
+// RFC 2435 use, as counts then values like in a DHT.
+var stdHuffman = [2][2][]byte{
+	{
+		{
+			0, 1, 5, 1, 1, 1, 1, 1, 1, 0, 0, 0, 0, 0, 0, 0,
+			0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11,
+		},
+		{
+			0, 2, 1, 3, 3, 2, 4, 3, 5, 5, 4, 4, 0, 0, 1, 125,
+			0x01, 0x02, 0x03, 0x00, 0x04, 0x11, 0x05, 0x12,
+			0x21, 0x31, 0x41, 0x06, 0x13, 0x51, 0x61, 0x07,
+			0x22, 0x71, 0x14, 0x32, 0x81, 0x91, 0xa1, 0x08,
+			0x23, 0x42, 0xb1, 0xc1, 0x15, 0x52, 0xd1, 0xf0,
+			0x24, 0x33, 0x62, 0x72, 0x82, 0x09, 0x0a, 0x16,
+			0x17, 0x18, 0x19, 0x1a, 0x25, 0x26, 0x27, 0x28,
+			0x29, 0x2a, 0x34, 0x35, 0x36, 0x37, 0x38, 0x39,
+			0x3a, 0x43, 0x44, 0x45, 0x46, 0x47, 0x48, 0x49,
+			0x4a, 0x53, 0x54, 0x55, 0x56, 0x57, 0x58, 0x59,
+			0x5a, 0x63, 0x64, 0x65, 0x66, 0x67, 0x68, 0x69,
+			0x6a, 0x73, 0x74, 0x75, 0x76, 0x77, 0x78, 0x79,
+			0x7a, 0x83, 0x84, 0x85, 0x86, 0x87, 0x88, 0x89,
+			0x8a, 0x92, 0x93, 0x94, 0x95, 0x96, 0x97, 0x98,
+			0x99, 0x9a, 0xa2, 0xa3, 0xa4, 0xa5, 0xa6, 0xa7,
+			0xa8, 0xa9, 0xaa, 0xb2, 0xb3, 0xb4, 0xb5, 0xb6,
+			0xb7, 0xb8, 0xb9, 0xba, 0xc2, 0xc3, 0xc4, 0xc5,
+			0xc6, 0xc7, 0xc8, 0xc9, 0xca, 0xd2, 0xd3, 0xd4,
+			0xd5, 0xd6, 0xd7, 0xd8, 0xd9, 0xda, 0xe1, 0xe2,
+			0xe3, 0xe4, 0xe5, 0xe6, 0xe7, 0xe8, 0xe9, 0xea,
+			0xf1, 0xf2, 0xf3, 0xf4, 0xf5, 0xf6, 0xf7, 0xf8,
+			0xf9, 0xfa,
+		},
+	},
+	{
+		{
+			0, 3, 1, 1, 1, 1, 1, 1, 1, 1, 1, 0, 0, 0, 0, 0,
+			0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11,
+		},
+		{
+			0, 2, 1, 2, 4, 4, 3, 4, 7, 5, 4, 4, 0, 1, 2, 119,
+			0x00, 0x01, 0x02, 0x03, 0x11, 0x04, 0x05, 0x21,
+			0x31, 0x06, 0x12, 0x41, 0x51, 0x07, 0x61, 0x71,
+			0x13, 0x22, 0x32, 0x81, 0x08, 0x14, 0x42, 0x91,
+			0xa1, 0xb1, 0xc1, 0x09, 0x23, 0x33, 0x52, 0xf0,
+			0x15, 0x62, 0x72, 0xd1, 0x0a, 0x16, 0x24, 0x34,
+			0xe1, 0x25, 0xf1, 0x17, 0x18, 0x19, 0x1a, 0x26,
+			0x27, 0x28, 0x29, 0x2a, 0x35, 0x36, 0x37, 0x38,
+			0x39, 0x3a, 0x43, 0x44, 0x45, 0x46, 0x47, 0x48,
+			0x49, 0x4a, 0x53, 0x54, 0x55, 0x56, 0x57, 0x58,
+			0x59, 0x5a, 0x63, 0x64, 0x65, 0x66, 0x67, 0x68,
+			0x69, 0x6a, 0x73, 0x74, 0x75, 0x76, 0x77, 0x78,
+			0x79, 0x7a, 0x82, 0x83, 0x84, 0x85, 0x86, 0x87,
+			0x88, 0x89, 0x8a, 0x92, 0x93, 0x94, 0x95, 0x96,
+			0x97, 0x98, 0x99, 0x9a, 0xa2, 0xa3, 0xa4, 0xa5,
+			0xa6, 0xa7, 0xa8, 0xa9, 0xaa, 0xb2, 0xb3, 0xb4,
+			0xb5, 0xb6, 0xb7, 0xb8, 0xb9, 0xba, 0xc2, 0xc3,
+			0xc4, 0xc5, 0xc6, 0xc7, 0xc8, 0xc9, 0xca, 0xd2,
+			0xd3, 0xd4, 0xd5, 0xd6, 0xd7, 0xd8, 0xd9, 0xda,
+			0xe2, 0xe3, 0xe4, 0xe5, 0xe6, 0xe7, 0xe8, 0xe9,
+			0xea, 0xf2, 0xf3, 0xf4, 0xf5, 0xf6, 0xf7, 0xf8,
+			0xf9, 0xfa,
+		},
+	},
+}
@@ -0,0 +1,27 @@
+package rtsp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/jpeg"
+	"testing"
+)
+
+func TestParseJPEGEmptyScan(t *testing.T) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewYCbCr(image.Rect(0, 0, 16, 16), image.YCbCrSubsampleRatio420), nil); err != nil {
+		t.Fatal(err)
+	}
+	b := buf.Bytes()
+	sos := bytes.Index(b, []byte{0xFF, 0xDA})
+	if sos < 0 {
+		t.Fatal("no SOS")
+	}
+	// The SOS header is followed directly by EOI.
+	end := sos + 2 + int(binary.BigEndian.Uint16(b[sos+2:]))
+	b = append(b[:end:end], 0xFF, 0xD9)
+	if _, err := parseJPEG(b); err == nil {
+		t.Fatal("parseJPEG accepted an empty scan")
+	}
+}
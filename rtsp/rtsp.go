@@ -0,0 +1,547 @@
+// Package rtsp serves Streams over RTSP, the frames being sent in RTP as
+// RFC 2435 JPEG, so that VLC, ffmpeg, NVRs and VMS software pull them
+// natively, e.g. rtsp://host:8554/cam1. The viewers play over UDP or
+// interleaved in the RTSP connection.
+//
+// RFC 2435 carries baseline YCbCr 4:2:2 and 4:2:0 JPEG with the standard
+// Huffman tables, of up to 2040x2040, which cameras and image/jpeg produce.
+// Other frames are re-encoded.
+package rtsp
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/WarehouseRobotics/go-mjpeg"
+)
+
+// ErrServerClosed is returned by Serve and ListenAndServe after Close.
+var ErrServerClosed = errors.New("rtsp: server closed")
+
+// maxBody is the size of the largest body of a request.
+const maxBody = 64 << 10
+
+// Option is an option of New
+type Option func(*options)
+
+type options struct {
+	lookup  func(name string) (*mjpeg.Stream, bool)
+	quality int
+	timeout time.Duration
+	onError func(error)
+}
+
+// WithLookup sets lookup to find the streams which were not given to Handle,
+// e.g. Hub.Stream to serve the streams of a Hub under their names.
+func WithLookup(lookup func(name string) (*mjpeg.Stream, bool)) Option {
+	return func(o *options) {
+		o.lookup = lookup
+	}
+}
+
+// WithQuality sets the quality of the frames which are re-encoded for RFC
+// 2435, 90 by default.
+func WithQuality(q int) Option {
+	return func(o *options) {
+		o.quality = q
+	}
+}
+
+// WithSessionTimeout sets the time after which a UDP session without request
+// nor RTCP from the viewer ends, 60s by default.
+func WithSessionTimeout(d time.Duration) Option {
+	return func(o *options) {
+		if d > 0 {
+			o.timeout = d
+		}
+	}
+}
+
+// WithErrorFunc sets f to be called with the frames which cannot be sent.
+// They are skipped.
+func WithErrorFunc(f func(error)) Option {
+	return func(o *options) {
+		o.onError = f
+	}
+}
+
+// Server is an RTSP server of Streams.
+type Server struct {
+	opts options
+
+	m         sync.Mutex
+	streams   map[string]*mjpeg.Stream
+	mounts    map[*mjpeg.Stream]*mount
+	sessions  map[string]*session
+	listeners map[net.Listener]struct{}
+	conns     map[*conn]struct{}
+	closed    bool
+}
+
+// New returns a Server without streams.
+func New(opts ...Option) *Server {
+	srv := &Server{
+		opts:      options{quality: 90, timeout: 60 * time.Second},
+		streams:   make(map[string]*mjpeg.Stream),
+		mounts:    make(map[*mjpeg.Stream]*mount),
+		sessions:  make(map[string]*session),
+		listeners: make(map[net.Listener]struct{}),
+		conns:     make(map[*conn]struct{}),
+	}
+	for _, opt := range opts {
+		opt(&srv.opts)
+	}
+	return srv
+}
+
+// Handle serves s at the path name, without the leading slash.
+func (srv *Server) Handle(name string, s *mjpeg.Stream) {
+	srv.m.Lock()
+	defer srv.m.Unlock()
+	srv.streams[strings.Trim(name, "/")] = s
+}
+
+// stream returns the stream of name.
+func (srv *Server) stream(name string) (*mjpeg.Stream, bool) {
+	srv.m.Lock()
+	s, ok := srv.streams[name]
+	srv.m.Unlock()
+	if !ok && srv.opts.lookup != nil {
+		s, ok = srv.opts.lookup(name)
+	}
+	return s, ok
+}
+
+// ListenAndServe listens on the TCP address addr, ":8554" when empty, and
+// serves the viewers.
+func (srv *Server) ListenAndServe(addr string) error {
+	if addr == "" {
+		addr = ":8554"
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return srv.Serve(l)
+}
+
+// Serve serves the viewers connecting to l, until Close. It closes l.
+func (srv *Server) Serve(l net.Listener) error {
+	srv.m.Lock()
+	if srv.closed {
+		srv.m.Unlock()
+		l.Close()
+		return ErrServerClosed
+	}
+	srv.listeners[l] = struct{}{}
+	srv.m.Unlock()
+	defer func() {
+		srv.m.Lock()
+		delete(srv.listeners, l)
+		srv.m.Unlock()
+		l.Close()
+	}()
+
+	for {
+		nc, err := l.Accept()
+		if err != nil {
+			srv.m.Lock()
+			closed := srv.closed
+			srv.m.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				time.Sleep(10 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+		c := &conn{srv: srv, nc: nc, br: bufio.NewReader(nc)}
+		srv.m.Lock()
+		if srv.closed {
+			srv.m.Unlock()
+			nc.Close()
+			return ErrServerClosed
+		}
+		srv.conns[c] = struct{}{}
+		srv.m.Unlock()
+		go c.serve()
+	}
+}
+
+// Close stops the listeners, the connections and the sessions.
+func (srv *Server) Close() error {
+	srv.m.Lock()
+	srv.closed = true
+	for l := range srv.listeners {
+		l.Close()
+	}
+	for c := range srv.conns {
+		c.nc.Close()
+	}
+	sessions := make([]*session, 0, len(srv.sessions))
+	for _, s := range srv.sessions {
+		sessions = append(sessions, s)
+	}
+	srv.m.Unlock()
+	for _, s := range sessions {
+		s.close()
+	}
+	return nil
+}
+
+func (srv *Server) error(err error) {
+	if srv.opts.onError != nil {
+		srv.opts.onError(err)
+	}
+}
+
+// mount shares the payloads of the frames of a stream between its sessions.
+type mount struct {
+	refs int
+
+	m   sync.Mutex
+	f   *mjpeg.Frame
+	j   *jpegFrame
+	err error
+}
+
+// jpeg returns the payloads of f, cut once for all the sessions.
+func (mt *mount) jpeg(f *mjpeg.Frame, quality int) (*jpegFrame, error) {
+	mt.m.Lock()
+	defer mt.m.Unlock()
+	if mt.f != f {
+		mt.f = f
+		mt.j, mt.err = packJPEG(f.Data, quality)
+	}
+	return mt.j, mt.err
+}
+
+func (srv *Server) acquire(s *mjpeg.Stream) *mount {
+	srv.m.Lock()
+	defer srv.m.Unlock()
+	mt := srv.mounts[s]
+	if mt == nil {
+		mt = &mount{}
+		srv.mounts[s] = mt
+	}
+	mt.refs++
+	return mt
+}
+
+func (srv *Server) release(s *mjpeg.Stream) {
+	srv.m.Lock()
+	defer srv.m.Unlock()
+	if mt := srv.mounts[s]; mt != nil {
+		if mt.refs--; mt.refs == 0 {
+			delete(srv.mounts, s)
+		}
+	}
+}
+
+// request is an RTSP request.
+type request struct {
+	method string
+	url    *url.URL
+	rawURL string
+	header textproto.MIMEHeader
+}
+
+// response is the answer to a request, its headers are kept in order.
+type response struct {
+	status int
+	header []string
+	body   []byte
+}
+
+func (r *response) set(key, value string) {
+	r.header = append(r.header, key, value)
+}
+
+var statusText = map[int]string{
+	200: "OK",
+	400: "Bad Request",
+	404: "Not Found",
+	454: "Session Not Found",
+	459: "Aggregate Operation Not Allowed",
+	461: "Unsupported Transport",
+	500: "Internal Server Error",
+	501: "Not Implemented",
+	503: "Service Unavailable",
+}
+
+// conn is the RTSP connection of a viewer.
+type conn struct {
+	srv *Server
+	nc  net.Conn
+	br  *bufio.Reader
+	// wm orders the responses and the interleaved packets.
+	wm sync.Mutex
+	// sessions are the sessions interleaved in the connection, which end
+	// with it.
+	sessions []*session
+}
+
+func (c *conn) serve() {
+	defer func() {
+		c.srv.m.Lock()
+		delete(c.srv.conns, c)
+		c.srv.m.Unlock()
+		c.nc.Close()
+		for _, s := range c.sessions {
+			s.close()
+		}
+	}()
+	tp := textproto.NewReader(c.br)
+	for {
+		b, err := c.br.Peek(1)
+		if err != nil {
+			return
+		}
+		// RTCP of the viewer interleaved in the connection.
+		if b[0] == '$' {
+			var h [4]byte
+			if _, err := io.ReadFull(c.br, h[:]); err != nil {
+				return
+			}
+			if _, err := c.br.Discard(int(h[2])<<8 | int(h[3])); err != nil {
+				return
+			}
+			for _, s := range c.sessions {
+				s.refresh()
+			}
+			continue
+		}
+		req, cseq, err := readRequest(tp)
+		if err != nil {
+			if cseq != "" {
+				c.write(cseq, &response{status: 400})
+			}
+			return
+		}
+		resp := c.handle(req)
+		if err := c.write(cseq, resp); err != nil {
+			return
+		}
+	}
+}
+
+// readRequest reads a request and returns it with its CSeq.
+func readRequest(tp *textproto.Reader) (*request, string, error) {
+	line, err := tp.ReadLine()
+	if err != nil {
+		return nil, "", err
+	}
+	h, err := tp.ReadMIMEHeader()
+	if err != nil {
+		return nil, "", err
+	}
+	cseq := h.Get("CSeq")
+	method, rest, ok1 := strings.Cut(line, " ")
+	rawURL, proto, ok2 := strings.Cut(rest, " ")
+	if !ok1 || !ok2 || !strings.HasPrefix(proto, "RTSP/1.") {
+		return nil, cseq, fmt.Errorf("rtsp: malformed request line %q", line)
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, cseq, err
+	}
+	if n := h.Get("Content-Length"); n != "" {
+		size, err := strconv.Atoi(n)
+		if err != nil || size < 0 || size > maxBody {
+			return nil, cseq, errors.New("rtsp: invalid Content-Length")
+		}
+		if _, err := io.CopyN(io.Discard, tp.R, int64(size)); err != nil {
+			return nil, cseq, err
+		}
+	}
+	return &request{method: method, url: u, rawURL: rawURL, header: h}, cseq, nil
+}
+
+func (c *conn) write(cseq string, r *response) error {
+	var b bytes.Buffer
+	text := statusText[r.status]
+	fmt.Fprintf(&b, "RTSP/1.0 %d %s\r\nCSeq: %s\r\n", r.status, text, cseq)
+	for i := 0; i < len(r.header); i += 2 {
+		fmt.Fprintf(&b, "%s: %s\r\n", r.header[i], r.header[i+1])
+	}
+	if len(r.body) > 0 {
+		fmt.Fprintf(&b, "Content-Length: %d\r\n", len(r.body))
+	}
+	b.WriteString("\r\n")
+	b.Write(r.body)
+	c.wm.Lock()
+	defer c.wm.Unlock()
+	c.nc.SetWriteDeadline(time.Now().Add(writeTimeout))
+	_, err := c.nc.Write(b.Bytes())
+	return err
+}
+
+func (c *conn) handle(req *request) *response {
+	var s *session
+	if id := req.header.Get("Session"); id != "" {
+		id, _, _ = strings.Cut(id, ";")
+		c.srv.m.Lock()
+		s = c.srv.sessions[strings.TrimSpace(id)]
+		c.srv.m.Unlock()
+		if s == nil {
+			return &response{status: 454}
+		}
+		s.refresh()
+	}
+	switch req.method {
+	case "OPTIONS":
+		r := &response{status: 200}
+		r.set("Public", "OPTIONS, DESCRIBE, SETUP, PLAY, TEARDOWN, GET_PARAMETER, SET_PARAMETER")
+		return r
+	case "DESCRIBE":
+		return c.describe(req)
+	case "SETUP":
+		if s != nil {
+			// A single track is served.
+			return &response{status: 459}
+		}
+		return c.setup(req)
+	case "PLAY":
+		if s == nil {
+			return &response{status: 454}
+		}
+		seq, rtptime := s.play()
+		r := &response{status: 200}
+		r.set("Session", s.id)
+		r.set("Range", "npt=0.000-")
+		r.set("RTP-Info", fmt.Sprintf("url=%s;seq=%d;rtptime=%d", req.rawURL, seq, rtptime))
+		return r
+	case "TEARDOWN":
+		if s == nil {
+			return &response{status: 454}
+		}
+		s.close()
+		return &response{status: 200}
+	case "GET_PARAMETER", "SET_PARAMETER":
+		// Keep-alive of the session.
+		return &response{status: 200}
+	}
+	return &response{status: 501}
+}
+
+// trackControl is the control URL of the track, relative to the stream.
+const trackControl = "trackID=0"
+
+func (c *conn) describe(req *request) *response {
+	name := strings.Trim(req.url.Path, "/")
+	if _, ok := c.srv.stream(name); !ok {
+		return &response{status: 404}
+	}
+	family, ip, unspecified := "IP4", "0.0.0.0", "0.0.0.0"
+	if a, ok := c.nc.LocalAddr().(*net.TCPAddr); ok {
+		ip = a.IP.String()
+		if a.IP.To4() == nil {
+			family, unspecified = "IP6", "::"
+		}
+	}
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "v=0\r\no=- %d 1 IN %s %s\r\ns=%s\r\nc=IN %s %s\r\nt=0 0\r\n",
+		time.Now().Unix(), family, ip, name, family, unspecified)
+	fmt.Fprintf(&b, "m=video 0 RTP/AVP %d\r\na=rtpmap:%d JPEG/%d\r\na=control:%s\r\n", payloadType, payloadType, clockRate, trackControl)
+	r := &response{status: 200, body: b.Bytes()}
+	r.set("Content-Type", "application/sdp")
+	r.set("Content-Base", strings.TrimSuffix(req.rawURL, "/")+"/")
+	return r
+}
+
+func (c *conn) setup(req *request) *response {
+	name := strings.Trim(strings.TrimSuffix(strings.TrimSuffix(req.url.Path, "/"), trackControl), "/")
+	st, ok := c.srv.stream(name)
+	if !ok {
+		return &response{status: 404}
+	}
+	t, reply, err := c.transport(req.header.Get("Transport"))
+	if err != nil {
+		return &response{status: 461}
+	}
+	s, err := c.srv.newSession(st, t)
+	if err != nil {
+		t.close()
+		return &response{status: 500}
+	}
+	if _, ok := t.(*tcpTransport); ok {
+		c.sessions = append(c.sessions, s)
+	}
+	r := &response{status: 200}
+	r.set("Transport", fmt.Sprintf("%s;ssrc=%08X", reply, s.ssrc))
+	r.set("Session", fmt.Sprintf("%s;timeout=%d", s.id, int(c.srv.opts.timeout.Seconds())))
+	return r
+}
+
+// transport returns the first transport of the Transport header h which is
+// supported, unicast UDP or TCP interleaved, with its Transport answer.
+func (c *conn) transport(h string) (transport, string, error) {
+	for _, spec := range strings.Split(h, ",") {
+		parts := strings.Split(strings.TrimSpace(spec), ";")
+		params := make(map[string]string)
+		for _, p := range parts[1:] {
+			k, v, _ := strings.Cut(p, "=")
+			params[strings.ToLower(strings.TrimSpace(k))] = strings.TrimSpace(v)
+		}
+		if _, ok := params["multicast"]; ok {
+			continue
+		}
+		switch strings.ToUpper(parts[0]) {
+		case "RTP/AVP/TCP":
+			rtp, rtcp := 0, 1
+			if v, ok := params["interleaved"]; ok {
+				var ok bool
+				if rtp, rtcp, ok = portRange(v); !ok || rtp > 255 || rtcp > 255 {
+					continue
+				}
+			}
+			return &tcpTransport{c: c, rtp: byte(rtp), rtcp: byte(rtcp)},
+				fmt.Sprintf("RTP/AVP/TCP;unicast;interleaved=%d-%d", rtp, rtcp), nil
+		case "RTP/AVP", "RTP/AVP/UDP":
+			rtp, rtcp, ok := portRange(params["client_port"])
+			if !ok {
+				continue
+			}
+			addr, ok := c.nc.RemoteAddr().(*net.TCPAddr)
+			if !ok {
+				continue
+			}
+			t, err := newUDPTransport(addr.IP, rtp, rtcp)
+			if err != nil {
+				return nil, "", err
+			}
+			return t, fmt.Sprintf("RTP/AVP;unicast;client_port=%d-%d;server_port=%d-%d", rtp, rtcp, t.port, t.port+1), nil
+		}
+	}
+	return nil, "", errors.New("rtsp: unsupported transport")
+}
+
+// portRange parses a port range, e.g. 5000-5001. The second port is the
+// next one when missing.
+func portRange(v string) (int, int, bool) {
+	a, b, found := strings.Cut(v, "-")
+	p, err := strconv.Atoi(a)
+	if err != nil || p < 0 || p > 65535 {
+		return 0, 0, false
+	}
+	q := p + 1
+	if found {
+		if q, err = strconv.Atoi(b); err != nil || q < 0 || q > 65535 {
+			return 0, 0, false
+		}
+	}
+	return p, q, true
+}
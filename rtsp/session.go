@@ -0,0 +1,341 @@
+package rtsp
+
+import (
+	"context"
+	crand "crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"math/rand/v2"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/WarehouseRobotics/go-mjpeg"
+	"github.com/WarehouseRobotics/go-mjpeg/internal/timescale"
+)
+
+const (
+	// payloadType is the static RTP payload type of JPEG, RFC 3551.
+	payloadType = 26
+	clockRate   = 90000
+
+	rtpHeaderSize = 12
+	// writeTimeout is the time after which a viewer which does not take the
+	// packets is dropped.
+	writeTimeout = 5 * time.Second
+	// reportInterval is the time between the RTCP sender reports.
+	reportInterval = 5 * time.Second
+)
+
+// transport sends the packets of a session.
+type transport interface {
+	writeRTP(p []byte) error
+	writeRTCP(p []byte) error
+	close()
+}
+
+// tcpTransport interleaves the packets in the RTSP connection, RFC 2326
+// 10.12.
+type tcpTransport struct {
+	c         *conn
+	rtp, rtcp byte
+	header    [4]byte
+}
+
+func (t *tcpTransport) writeRTP(p []byte) error {
+	return t.write(t.rtp, p)
+}
+
+func (t *tcpTransport) writeRTCP(p []byte) error {
+	return t.write(t.rtcp, p)
+}
+
+func (t *tcpTransport) write(ch byte, p []byte) error {
+	t.c.wm.Lock()
+	defer t.c.wm.Unlock()
+	t.header = [4]byte{'$', ch, byte(len(p) >> 8), byte(len(p))}
+	t.c.nc.SetWriteDeadline(time.Now().Add(writeTimeout))
+	bufs := net.Buffers{t.header[:], p}
+	_, err := bufs.WriteTo(t.c.nc)
+	return err
+}
+
+// close leaves the connection to the viewer.
+func (t *tcpTransport) close() {}
+
+// udpTransport sends the packets to the ports of the viewer from a pair of
+// ports of the server.
+type udpTransport struct {
+	rtp, rtcp       *net.UDPConn
+	dstRTP, dstRTCP *net.UDPAddr
+	port            int
+}
+
+// newUDPTransport listens on a free pair of ports, an even one for RTP and
+// the next one for RTCP, to send to ip.
+func newUDPTransport(ip net.IP, rtpPort, rtcpPort int) (*udpTransport, error) {
+	for range 16 {
+		rtp, err := net.ListenUDP("udp", &net.UDPAddr{})
+		if err != nil {
+			return nil, err
+		}
+		port := rtp.LocalAddr().(*net.UDPAddr).Port
+		if port%2 == 0 {
+			if rtcp, err := net.ListenUDP("udp", &net.UDPAddr{Port: port + 1}); err == nil {
+				return &udpTransport{
+					rtp:     rtp,
+					rtcp:    rtcp,
+					dstRTP:  &net.UDPAddr{IP: ip, Port: rtpPort},
+					dstRTCP: &net.UDPAddr{IP: ip, Port: rtcpPort},
+					port:    port,
+				}, nil
+			}
+		}
+		rtp.Close()
+	}
+	return nil, errors.New("rtsp: no free UDP port pair")
+}
+
+func (t *udpTransport) writeRTP(p []byte) error {
+	_, err := t.rtp.WriteToUDP(p, t.dstRTP)
+	return err
+}
+
+func (t *udpTransport) writeRTCP(p []byte) error {
+	_, err := t.rtcp.WriteToUDP(p, t.dstRTCP)
+	return err
+}
+
+// read calls seen for each RTCP packet of the viewer, until close.
+func (t *udpTransport) read(seen func()) {
+	buf := make([]byte, 1500)
+	for {
+		if _, _, err := t.rtcp.ReadFromUDP(buf); err != nil {
+			return
+		}
+		seen()
+	}
+}
+
+func (t *udpTransport) close() {
+	t.rtp.Close()
+	t.rtcp.Close()
+}
+
+// session sends the frames of a stream to a viewer once it plays.
+type session struct {
+	id     string
+	srv    *Server
+	stream *mjpeg.Stream
+	mount  *mount
+	t      transport
+
+	ssrc uint32
+	// seq and rtptime are the first sequence number and timestamp.
+	seq     uint16
+	rtptime uint32
+	// seen is the time of the last request or RTCP of the viewer, in Unix
+	// nanoseconds.
+	seen atomic.Int64
+
+	m       sync.Mutex
+	playing bool
+	cancel  context.CancelFunc
+	once    sync.Once
+	// done is closed by close.
+	done chan struct{}
+}
+
+func (srv *Server) newSession(st *mjpeg.Stream, t transport) (*session, error) {
+	var id [8]byte
+	if _, err := crand.Read(id[:]); err != nil {
+		return nil, err
+	}
+	s := &session{
+		id:      hex.EncodeToString(id[:]),
+		srv:     srv,
+		stream:  st,
+		t:       t,
+		ssrc:    rand.Uint32(),
+		seq:     uint16(rand.Uint32()),
+		rtptime: rand.Uint32(),
+		done:    make(chan struct{}),
+	}
+	s.refresh()
+	srv.m.Lock()
+	if srv.closed {
+		srv.m.Unlock()
+		return nil, ErrServerClosed
+	}
+	srv.sessions[s.id] = s
+	srv.m.Unlock()
+	s.mount = srv.acquire(st)
+	if u, ok := t.(*udpTransport); ok {
+		go u.read(s.refresh)
+		go s.watch()
+	}
+	return s, nil
+}
+
+func (s *session) refresh() {
+	s.seen.Store(time.Now().UnixNano())
+}
+
+// watch closes the session once the viewer is silent for the timeout, played
+// or not. The sessions over TCP end with the connection instead.
+func (s *session) watch() {
+	tick := time.NewTicker(min(s.srv.opts.timeout/2, reportInterval))
+	defer tick.Stop()
+	for {
+		select {
+		case <-tick.C:
+			if time.Since(time.Unix(0, s.seen.Load())) > s.srv.opts.timeout {
+				s.close()
+				return
+			}
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// play starts sending the frames, and returns the first sequence number and
+// timestamp.
+func (s *session) play() (uint16, uint32) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	if !s.playing {
+		s.playing = true
+		var ctx context.Context
+		ctx, s.cancel = context.WithCancel(context.Background())
+		go s.run(ctx)
+	}
+	return s.seq, s.rtptime
+}
+
+func (s *session) run(ctx context.Context) {
+	sub, err := s.stream.Subscribe()
+	if err != nil {
+		s.close()
+		return
+	}
+	defer sub.Close()
+	tick := time.NewTicker(reportInterval)
+	defer tick.Stop()
+
+	var (
+		start           time.Time
+		seq             = s.seq
+		last            uint32
+		lastSent        time.Time
+		packets, octets uint32
+		buf             = make([]byte, rtpHeaderSize, rtpHeaderSize+maxPayload)
+	)
+	for {
+		select {
+		case f, ok := <-sub.C:
+			if !ok {
+				s.close()
+				return
+			}
+			j, err := s.mount.jpeg(f, s.srv.opts.quality)
+			if err != nil {
+				s.srv.error(err)
+				continue
+			}
+			t := f.Time
+			if t.IsZero() {
+				t = time.Now()
+			}
+			if start.IsZero() {
+				start = t
+			}
+			ts := s.rtptime + uint32(timescale.Ticks(t.Sub(start), clockRate))
+			// The frames must have distinct timestamps.
+			if packets > 0 && int32(ts-last) <= 0 {
+				ts = last + 1
+			}
+			for i, p := range j.payloads {
+				buf = buf[:rtpHeaderSize]
+				buf[0] = 0x80
+				buf[1] = payloadType
+				if i == len(j.payloads)-1 {
+					buf[1] |= 0x80
+				}
+				binary.BigEndian.PutUint16(buf[2:], seq)
+				binary.BigEndian.PutUint32(buf[4:], ts)
+				binary.BigEndian.PutUint32(buf[8:], s.ssrc)
+				buf = append(buf, p...)
+				if err := s.t.writeRTP(buf); err != nil {
+					if ctx.Err() == nil {
+						s.close()
+					}
+					return
+				}
+				seq++
+				packets++
+				octets += uint32(len(p))
+			}
+			last, lastSent = ts, time.Now()
+		case <-tick.C:
+			if packets > 0 {
+				now := time.Now()
+				ts := last + uint32(timescale.Ticks(now.Sub(lastSent), clockRate))
+				s.t.writeRTCP(s.senderReport(now, ts, packets, octets))
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// senderReport returns an RTCP sender report with the CNAME of the session,
+// RFC 3550 6.4.1.
+func (s *session) senderReport(now time.Time, ts, packets, octets uint32) []byte {
+	be := binary.BigEndian
+	b := []byte{0x80, 200, 0, 6}
+	b = be.AppendUint32(b, s.ssrc)
+	// NTP time, from 1900.
+	b = be.AppendUint32(b, uint32(now.Unix()+2208988800))
+	b = be.AppendUint32(b, uint32(uint64(now.Nanosecond())<<32/uint64(time.Second)))
+	b = be.AppendUint32(b, ts)
+	b = be.AppendUint32(b, packets)
+	b = be.AppendUint32(b, octets)
+
+	sdes := len(b)
+	b = append(b, 0x81, 202, 0, 0)
+	b = be.AppendUint32(b, s.ssrc)
+	b = append(b, 1, byte(len(s.id)))
+	b = append(b, s.id...)
+	// The items end with a null octet, padded to 32 bits.
+	b = append(b, 0)
+	for len(b)%4 != 0 {
+		b = append(b, 0)
+	}
+	be.PutUint16(b[sdes+2:], uint16((len(b)-sdes)/4-1))
+	return b
+}
+
+// close ends the session, telling the viewer with an RTCP BYE.
+func (s *session) close() {
+	s.once.Do(func() {
+		close(s.done)
+		s.m.Lock()
+		playing := s.playing
+		if s.cancel != nil {
+			s.cancel()
+		}
+		s.m.Unlock()
+		s.srv.m.Lock()
+		delete(s.srv.sessions, s.id)
+		s.srv.m.Unlock()
+		s.srv.release(s.stream)
+		if playing {
+			bye := binary.BigEndian.AppendUint32([]byte{0x81, 203, 0, 1}, s.ssrc)
+			s.t.writeRTCP(bye)
+		}
+		s.t.close()
+	})
+}
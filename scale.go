@@ -0,0 +1,60 @@
+package mjpeg
+
+import (
+	"image"
+	"image/color"
+)
+
+// fitRect returns the largest rectangle of the aspect ratio of a w x h image
+// centered in r.
+func fitRect(r image.Rectangle, w, h int) image.Rectangle {
+	if w <= 0 || h <= 0 {
+		return r
+	}
+	fw, fh := r.Dx(), r.Dx()*h/w
+	if fh > r.Dy() {
+		fw, fh = r.Dy()*w/h, r.Dy()
+	}
+	x := r.Min.X + (r.Dx()-fw)/2
+	y := r.Min.Y + (r.Dy()-fh)/2
+	return image.Rect(x, y, x+fw, y+fh)
+}
+
+// scaleInto draws src scaled to r of dst, with nearest neighbor sampling.
+func scaleInto(dst *image.RGBA, r image.Rectangle, src image.Image) {
+	r = r.Intersect(dst.Rect)
+	sb := src.Bounds()
+	if r.Empty() || sb.Empty() {
+		return
+	}
+	xs := make([]int, r.Dx())
+	for i := range xs {
+		xs[i] = sb.Min.X + i*sb.Dx()/r.Dx()
+	}
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		sy := sb.Min.Y + (y-r.Min.Y)*sb.Dy()/r.Dy()
+		row := dst.Pix[dst.PixOffset(r.Min.X, y):]
+		switch src := src.(type) {
+		case *image.YCbCr:
+			for i, sx := range xs {
+				yi, ci := src.YOffset(sx, sy), src.COffset(sx, sy)
+				cr, cg, cb := color.YCbCrToRGB(src.Y[yi], src.Cb[ci], src.Cr[ci])
+				row[i*4], row[i*4+1], row[i*4+2], row[i*4+3] = cr, cg, cb, 0xff
+			}
+		case *image.RGBA:
+			for i, sx := range xs {
+				copy(row[i*4:i*4+4], src.Pix[src.PixOffset(sx, sy):])
+			}
+		case *image.Gray:
+			for i, sx := range xs {
+				g := src.Pix[src.PixOffset(sx, sy)]
+				row[i*4], row[i*4+1], row[i*4+2], row[i*4+3] = g, g, g, 0xff
+			}
+		default:
+			for i, sx := range xs {
+				c := color.RGBAModel.Convert(src.At(sx, sy)).(color.RGBA)
+				row[i*4], row[i*4+1], row[i*4+2], row[i*4+3] = c.R, c.G, c.B, c.A
+			}
+		}
+	}
+}
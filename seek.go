@@ -0,0 +1,229 @@
+package mjpeg
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"net/textproto"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrNoTimestamps is returned by SeekToTime when the recording has no part timestamps.
+var ErrNoTimestamps = errors.New("mjpeg: recording has no timestamps")
+
+// IndexEntry locates a frame in a recording
+type IndexEntry struct {
+	// Offset is the position of the boundary line, or of the SOI marker for
+	// boundary-less recordings.
+	Offset int64
+	// Time is taken from the X-Timestamp part header, zero when missing.
+	Time time.Time
+}
+
+// SeekableDecoder decode recorded motion jpeg, and seek in it by frame number or time
+type SeekableDecoder struct {
+	*Decoder
+
+	rs       io.ReadSeeker
+	boundary string
+	raw      bool
+	opts     []DecoderOption
+	index    []IndexEntry
+}
+
+// NewSeekableDecoder return new instance of SeekableDecoder. It reads the
+// whole recording once to build the frame index, and is positioned at the
+// first frame afterwards. When boundary is empty it is taken from the first
+// line, and a recording starting with a JPEG SOI marker is read as
+// concatenated JPEG images.
+func NewSeekableDecoder(rs io.ReadSeeker, boundary string, opts ...DecoderOption) (*SeekableDecoder, error) {
+	s := &SeekableDecoder{rs: rs, opts: opts}
+	if _, err := rs.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	br := bufio.NewReaderSize(rs, sniffLen)
+	if boundary == "" {
+		if startsWithSOI(br) {
+			s.raw = true
+		} else if b, ok := sniffBoundary(br); ok {
+			boundary = b
+		}
+	}
+	s.boundary = strings.Trim(boundary, "-")
+	if s.raw {
+		s.opts = append(s.opts[:len(s.opts):len(s.opts)], WithRawJPEG())
+	}
+
+	var err error
+	if s.raw {
+		s.index, err = indexRaw(br)
+	} else {
+		s.index, err = indexMultipart(br, s.boundary)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := s.SeekToFrame(0); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Index return the frame index of the recording.
+func (s *SeekableDecoder) Index() []IndexEntry {
+	return s.index
+}
+
+// Len return the number of frames in the recording.
+func (s *SeekableDecoder) Len() int {
+	return len(s.index)
+}
+
+// SeekToFrame position the decoder so that the next frame read is frame n, counting from 0.
+func (s *SeekableDecoder) SeekToFrame(n int) error {
+	if n < 0 || n > len(s.index) {
+		return errors.New("mjpeg: frame out of range")
+	}
+	off := int64(0)
+	if n < len(s.index) {
+		off = s.index[n].Offset
+	} else if n > 0 {
+		// Past the last frame, the decoder reaches EOF.
+		off, _ = s.rs.Seek(0, io.SeekEnd)
+	}
+	if _, err := s.rs.Seek(off, io.SeekStart); err != nil {
+		return err
+	}
+	d := NewDecoder(s.rs, s.boundary, s.opts...)
+	d.seq = uint64(n)
+	s.Decoder = d
+	return nil
+}
+
+// SeekToTime position the decoder at the first frame whose time is not before t.
+func (s *SeekableDecoder) SeekToTime(t time.Time) error {
+	if len(s.index) == 0 || s.index[0].Time.IsZero() {
+		return ErrNoTimestamps
+	}
+	n := sort.Search(len(s.index), func(i int) bool {
+		return !s.index[i].Time.Before(t)
+	})
+	return s.SeekToFrame(n)
+}
+
+// offsetReader tracks the position in the stream read through br.
+type offsetReader struct {
+	br  *bufio.Reader
+	off int64
+}
+
+func (o *offsetReader) readLine() ([]byte, bool, error) {
+	line, err := o.br.ReadSlice('\n')
+	o.off += int64(len(line))
+	if err == bufio.ErrBufferFull {
+		return line, false, nil
+	}
+	return line, true, err
+}
+
+func indexMultipart(br *bufio.Reader, boundary string) ([]IndexEntry, error) {
+	if boundary == "" {
+		return nil, errors.New("mjpeg: no boundary")
+	}
+	delim := []byte("--" + boundary)
+	or := &offsetReader{br: br}
+	var index []IndexEntry
+	atStart := true
+	for {
+		start := or.off
+		line, complete, err := or.readLine()
+		if err == io.EOF {
+			return index, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		isDelim := atStart && bytes.HasPrefix(line, delim)
+		atStart = complete
+		if !isDelim {
+			continue
+		}
+		rest := bytes.TrimSpace(line[len(delim):])
+		if bytes.Equal(rest, []byte("--")) {
+			return index, nil
+		}
+		if len(rest) != 0 {
+			continue
+		}
+
+		header := textproto.MIMEHeader{}
+		for {
+			line, _, err := or.readLine()
+			if err != nil {
+				return nil, noEOF(err)
+			}
+			line = bytes.TrimSpace(line)
+			if len(line) == 0 {
+				break
+			}
+			if i := bytes.IndexByte(line, ':'); i > 0 {
+				key := textproto.CanonicalMIMEHeaderKey(string(bytes.TrimSpace(line[:i])))
+				header.Add(key, string(bytes.TrimSpace(line[i+1:])))
+			}
+		}
+		index = append(index, IndexEntry{Offset: start, Time: parseTimestamp(header)})
+	}
+}
+
+func indexRaw(br *bufio.Reader) ([]IndexEntry, error) {
+	cr := &countingReader{r: br}
+	src := newRawSource(cr, 0)
+	var index []IndexEntry
+	for {
+		p, err := src.next()
+		if err == io.EOF {
+			return index, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		end := cr.n - int64(src.br.Buffered())
+		index = append(index, IndexEntry{Offset: end - int64(len(p.data))})
+		p.release()
+	}
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// parseTimestamp reads X-Timestamp as Unix seconds, fractional seconds or milliseconds.
+func parseTimestamp(h textproto.MIMEHeader) time.Time {
+	v := h.Get("X-Timestamp")
+	if v == "" {
+		return time.Time{}
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		if t, err := time.Parse(time.RFC3339Nano, v); err == nil {
+			return t
+		}
+		return time.Time{}
+	}
+	if f > 1e11 {
+		f /= 1000
+	}
+	sec := int64(f)
+	return time.Unix(sec, int64((f-float64(sec))*1e9))
+}
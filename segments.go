@@ -0,0 +1,88 @@
+package mjpeg
+
+import (
+	"bytes"
+)
+
+// Marker codes of the segments returned by ParseSegments.
+const (
+	MarkerAPP0 = 0xE0
+	MarkerAPP1 = 0xE1
+	MarkerCOM  = 0xFE
+)
+
+// Segment is an application (APPn) or comment (COM) segment of a JPEG image
+type Segment struct {
+	// Marker is the marker code, MarkerAPP0 to MarkerAPP0+15 or MarkerCOM.
+	Marker byte
+	// Data is the payload of the segment, without marker and length.
+	Data []byte
+}
+
+// ParseSegments return APPn and COM segments of the JPEG image b which appear
+// before the image data. The payloads share memory with b.
+func ParseSegments(b []byte) ([]Segment, error) {
+	if len(b) < 2 || b[0] != 0xFF || b[1] != 0xD8 {
+		return nil, errMalformedPart
+	}
+	var segs []Segment
+	b = b[2:]
+	for {
+		if len(b) < 2 || b[0] != 0xFF {
+			return segs, errMalformedPart
+		}
+		code := b[1]
+		if code == 0xFF {
+			b = b[1:]
+			continue
+		}
+		b = b[2:]
+		if code == 0xD9 || code == 0xDA {
+			return segs, nil
+		}
+		if code == 0x01 || (code >= 0xD0 && code <= 0xD7) {
+			continue
+		}
+		if len(b) < 2 {
+			return segs, errMalformedPart
+		}
+		n := int(b[0])<<8 | int(b[1])
+		if n < 2 || len(b) < n {
+			return segs, errMalformedPart
+		}
+		if (code >= MarkerAPP0 && code <= MarkerAPP0+15) || code == MarkerCOM {
+			segs = append(segs, Segment{Marker: code, Data: b[2:n]})
+		}
+		b = b[n:]
+	}
+}
+
+// Segments return APPn and COM segments of the frame, see ParseSegments.
+func (f *Frame) Segments() ([]Segment, error) {
+	return ParseSegments(f.Data)
+}
+
+var exifHeader = []byte("Exif\x00\x00")
+
+// EXIF return the TIFF structured EXIF data of the frame, or nil when it has none.
+func (f *Frame) EXIF() []byte {
+	segs, _ := f.Segments()
+	for _, s := range segs {
+		if s.Marker == MarkerAPP1 && bytes.HasPrefix(s.Data, exifHeader) {
+			return s.Data[len(exifHeader):]
+		}
+	}
+	return nil
+}
+
+// Comments return the text of the COM segments of the frame.
+func (f *Frame) Comments() []string {
+	segs, _ := f.Segments()
+	var cs []string
+	for _, s := range segs {
+		if s.Marker == MarkerCOM {
+			cs = append(cs, string(bytes.TrimRight(s.Data, "\x00")))
+		}
+	}
+	return cs
+}
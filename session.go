@@ -0,0 +1,71 @@
+package mjpeg
+
+import "net/http"
+
+// Session receives the events of a viewer of ServeHTTP, for tracing. Its
+// methods are called from the goroutine serving the viewer.
+type Session interface {
+	// Dropped reports n frames not delivered to the viewer since the last
+	// call.
+	Dropped(n uint64)
+	// Error reports a failed write, which ends the session.
+	Error(err error)
+	// End is called when the viewer is gone, with the number of frames and
+	// bytes written to it.
+	End(frames, bytes uint64)
+}
+
+// SessionFunc starts the Session of a viewer from its request.
+type SessionFunc func(r *http.Request, id WatcherID) Session
+
+// WithSessions sets f to be called when a viewer attaches, after the
+// WithOnConnect callback, to follow the viewer until it is gone.
+func WithSessions(f SessionFunc) StreamOption {
+	return func(s *Stream) {
+		s.sessions = f
+	}
+}
+
+// session follows the drops of c for sess.
+type session struct {
+	Session
+	c        *client
+	reported uint64
+}
+
+func (s *Stream) startSession(r *http.Request, c *client) *session {
+	if s.sessions == nil {
+		return nil
+	}
+	sess := s.sessions(r, c.id)
+	if sess == nil {
+		return nil
+	}
+	return &session{Session: sess, c: c}
+}
+
+// drops reports the frames dropped since the last call, nothing on a nil
+// session.
+func (ss *session) drops() {
+	if ss == nil {
+		return
+	}
+	if n := ss.c.dropped.Load(); n > ss.reported {
+		ss.Dropped(n - ss.reported)
+		ss.reported = n
+	}
+}
+
+func (ss *session) error(err error) {
+	if ss != nil {
+		ss.Error(err)
+	}
+}
+
+func (ss *session) end() {
+	if ss == nil {
+		return
+	}
+	ss.drops()
+	ss.End(ss.c.frames.Load(), ss.c.bytes.Load())
+}
@@ -0,0 +1,89 @@
+package mjpeg
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// WithFanoutShards spreads the clients of the stream over n sets, for streams
+// with thousands of clients on several CPUs. A client coming or going copies
+// only its set, and frames are delivered to the sets in parallel. n is best
+// around GOMAXPROCS, the default is a single set. On a single CPU sharding
+// does not pay: BenchmarkFanout delivers a frame to 10000 clients in about
+// 1ms with 1, 4 or 8 sets, and to 100 clients in 10µs with 1 set but 14µs
+// with 8.
+func WithFanoutShards(n int) StreamOption {
+	return func(s *Stream) {
+		s.nshards = n
+	}
+}
+
+// shard is a copy-on-write set of clients, replaced as a whole under s.m.
+type shard struct {
+	subs atomic.Pointer[[]*client]
+}
+
+func newShards(n int) []*shard {
+	shards := make([]*shard, max(n, 1))
+	for i := range shards {
+		shards[i] = &shard{}
+		shards[i].subs.Store(&[]*client{})
+	}
+	return shards
+}
+
+func (sh *shard) clients() []*client {
+	return *sh.subs.Load()
+}
+
+func (sh *shard) add(c *client) {
+	old := sh.clients()
+	subs := make([]*client, len(old), len(old)+1)
+	copy(subs, old)
+	subs = append(subs, c)
+	sh.subs.Store(&subs)
+}
+
+func (sh *shard) remove(c *client) {
+	old := sh.clients()
+	subs := make([]*client, 0, len(old))
+	for _, o := range old {
+		if o != c {
+			subs = append(subs, o)
+		}
+	}
+	sh.subs.Store(&subs)
+}
+
+// snapshot returns the clients of each shard. It is called with s.m held.
+func (s *Stream) snapshot() [][]*client {
+	snap := make([][]*client, len(s.shards))
+	for i, sh := range s.shards {
+		snap[i] = sh.clients()
+	}
+	return snap
+}
+
+// fanout sends f to the clients of snap, one goroutine per shard.
+func fanout(snap [][]*client, f *Frame) {
+	if len(snap) == 1 {
+		for _, c := range snap[0] {
+			c.send(f)
+		}
+		return
+	}
+	var wg sync.WaitGroup
+	for _, subs := range snap {
+		if len(subs) == 0 {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for _, c := range subs {
+				c.send(f)
+			}
+		}()
+	}
+	wg.Wait()
+}
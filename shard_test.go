@@ -0,0 +1,27 @@
+package mjpeg
+
+import (
+	"fmt"
+	"testing"
+)
+
+func BenchmarkFanout(b *testing.B) {
+	frame := gradientJPEG(b, 16, 16)
+	for _, n := range []int{100, 10000} {
+		for _, shards := range []int{1, 4, 8} {
+			b.Run(fmt.Sprintf("clients=%d/shards=%d", n, shards), func(b *testing.B) {
+				s := NewStream(WithFanoutShards(shards))
+				defer s.Close()
+				// The clients do not read, each frame replaces the one
+				// waiting like for a slow viewer.
+				for range n {
+					s.add(1, DropOldest, false)
+				}
+				b.ResetTimer()
+				for range b.N {
+					s.Update(frame)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package mjpeg
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// WithEndFrame sets the frame sent to the clients by Shutdown. Without it, a
+// "STREAM ENDED" frame is generated at the size of the last frame.
+func WithEndFrame(b []byte) StreamOption {
+	return func(s *Stream) {
+		s.endFrame = b
+	}
+}
+
+// Shutdown closes the stream gracefully, like http.Server.Shutdown: new
+// viewers are refused with 503 Service Unavailable, the clients get a last
+// frame which tells that the stream ended, then their responses end with the
+// closing boundary. Shutdown waits for the ServeHTTP handlers to return, or
+// disconnects those left when ctx is done and returns ctx.Err().
+func (s *Stream) Shutdown(ctx context.Context) error {
+	s.m.Lock()
+	closed := s.closed
+	s.shutdown = true
+	s.m.Unlock()
+
+	if !closed {
+		if f := s.endingFrame(); f != nil {
+			s.pubM.Lock()
+			s.m.Lock()
+			snap := s.snapshot()
+			s.m.Unlock()
+			for _, subs := range snap {
+				for _, c := range subs {
+					c.ending(f)
+				}
+			}
+			s.pubM.Unlock()
+		}
+		s.Close()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.handlers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		s.byID.Range(func(k, _ any) bool {
+			s.Disconnect(k.(WatcherID))
+			return true
+		})
+		return ctx.Err()
+	}
+}
+
+// ending hands f to c: the clients of ServeHTTP write it once c is closed,
+// so that the drop policy does not lose it, the subscriptions get it like a
+// frame.
+func (c *client) ending(f *Frame) {
+	if c.detach {
+		c.send(f)
+		return
+	}
+	c.m.Lock()
+	if !c.closed {
+		c.end = f
+	}
+	c.m.Unlock()
+}
+
+// enter registers a ServeHTTP handler for Shutdown, it returns false once
+// the stream is shut down.
+func (s *Stream) enter() bool {
+	s.m.Lock()
+	defer s.m.Unlock()
+	if s.shutdown {
+		return false
+	}
+	s.handlers.Add(1)
+	return true
+}
+
+// endingFrame returns the frame sent by Shutdown, nil if none can be made.
+func (s *Stream) endingFrame() *Frame {
+	if s.endFrame != nil {
+		return &Frame{Data: s.endFrame, Time: time.Now()}
+	}
+	now := time.Now()
+	w, h := s.frameSize()
+	var buf bytes.Buffer
+	if err := DefaultCodec.Encode(&buf, textImage(w, h, "STREAM ENDED", now), nil); err != nil {
+		return nil
+	}
+	return &Frame{Data: buf.Bytes(), Time: now}
+}
+
+func serviceUnavailable(w http.ResponseWriter) {
+	w.Header().Set("Retry-After", fmt.Sprint(int(retryAfter.Seconds())))
+	http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+}
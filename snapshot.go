@@ -0,0 +1,45 @@
+package mjpeg
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// ServeSnapshot writes the most recent frame as a single image response.
+// It waits for the first frame when there was none yet.
+func (s *Stream) ServeSnapshot(w http.ResponseWriter, r *http.Request) {
+	if s.cors(w, r) {
+		return
+	}
+	var f *Frame
+	if now := time.Now(); s.staleAfter > 0 && !s.Paused() && s.stale(now) {
+		f = s.placeholder(now)
+	}
+	if f == nil {
+		var err error
+		f, err = s.currentFrame(r.Context())
+		if err != nil {
+			return // client gone
+		}
+	}
+	if f == nil {
+		http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", f.ContentType())
+	w.Header().Set("Content-Length", fmt.Sprint(len(f.Data)))
+	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+	w.Header().Set("Pragma", "no-cache")
+	w.Header().Set("Expires", "0")
+	if r.Method == http.MethodHead {
+		return
+	}
+	w.Write(f.Data)
+}
+
+// SnapshotHandler returns an http.Handler calling ServeSnapshot.
+func (s *Stream) SnapshotHandler() http.Handler {
+	return http.HandlerFunc(s.ServeSnapshot)
+}
@@ -0,0 +1,58 @@
+package mjpeg
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+)
+
+const sniffLen = 4096
+
+// WithBoundarySniffing make NewDecoderFromResponse take the boundary from the
+// first boundary line of the body when it does not match the one announced in
+// Content-Type. The boundary is always sniffed when Content-Type has none.
+func WithBoundarySniffing() DecoderOption {
+	return func(o *decoderOptions) {
+		o.sniff = true
+	}
+}
+
+// sniffBoundary peeks at the beginning of r for a boundary line. r must be
+// used instead of the original reader afterwards.
+func sniffBoundary(br *bufio.Reader) (string, bool) {
+	for {
+		buf, err := br.Peek(br.Buffered())
+		if b, ok := detectBoundary(buf); ok {
+			return b, true
+		}
+		if err != nil || len(buf) >= sniffLen {
+			return "", false
+		}
+		if _, err := br.Peek(len(buf) + 1); err != nil && err != io.EOF {
+			return "", false
+		}
+		if br.Buffered() == len(buf) {
+			return "", false
+		}
+	}
+}
+
+// detectBoundary returns the boundary of the first complete boundary line in buf.
+func detectBoundary(buf []byte) (string, bool) {
+	for len(buf) > 0 {
+		i := bytes.IndexByte(buf, '\n')
+		if i < 0 {
+			return "", false
+		}
+		line := bytes.TrimSpace(buf[:i])
+		buf = buf[i+1:]
+		if len(line) == 0 {
+			continue
+		}
+		if bytes.HasPrefix(line, []byte("--")) && len(line) > 2 {
+			return string(line[2:]), true
+		}
+		return "", false
+	}
+	return "", false
+}
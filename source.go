@@ -0,0 +1,95 @@
+package mjpeg
+
+import (
+	"io"
+	"mime/multipart"
+)
+
+// partSource splits a stream into parts.
+type partSource interface {
+	next() (*part, error)
+	// skip discards the next part, with as little work as the framing allows.
+	skip() error
+}
+
+type multipartSource struct {
+	r        *multipart.Reader
+	src      *errRecorder
+	boundary string
+	limit    int
+	broken   bool
+}
+
+func newMultipartSource(r io.Reader, boundary string, limit int) *multipartSource {
+	src := &errRecorder{r: r}
+	return &multipartSource{
+		r:        multipart.NewReader(src, boundary),
+		src:      src,
+		boundary: boundary,
+		limit:    limit,
+	}
+}
+
+// errRecorder remembers the error of the underlying reader, so that parse
+// errors of multipart.Reader can be told from broken connections.
+type errRecorder struct {
+	r   io.Reader
+	err error
+}
+
+func (e *errRecorder) Read(p []byte) (int, error) {
+	n, err := e.r.Read(p)
+	if err != nil {
+		e.err = err
+	}
+	return n, err
+}
+
+// fail marks the reader for resync when err is a parse error. The multipart
+// reader stays unusable after one, so a new one is started which treats
+// everything up to the next boundary as preamble.
+func (s *multipartSource) fail(err error) error {
+	if s.src.err == nil {
+		s.broken = true
+	}
+	return err
+}
+
+func (s *multipartSource) resync() {
+	if s.broken {
+		s.r = multipart.NewReader(s.src, s.boundary)
+		s.broken = false
+	}
+}
+
+func (s *multipartSource) next() (*part, error) {
+	s.resync()
+	p, err := s.r.NextPart()
+	if err != nil {
+		return nil, s.fail(err)
+	}
+	pt := newPart()
+	pt.header = p.Header
+	var r io.Reader = p
+	if s.limit > 0 {
+		r = io.LimitReader(p, int64(s.limit)+1)
+	}
+	if pt.data, err = readAll(pt.data, r); err != nil {
+		pt.release()
+		return nil, s.fail(err)
+	}
+	if exceeds(len(pt.data), s.limit) {
+		pt.release()
+		return nil, &FrameSizeError{Limit: s.limit}
+	}
+	return pt, nil
+}
+
+func (s *multipartSource) skip() error {
+	// The body is discarded by the following NextPart without buffering.
+	s.resync()
+	if _, err := s.r.NextPart(); err != nil {
+		return s.fail(err)
+	}
+	return nil
+}
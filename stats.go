@@ -0,0 +1,135 @@
+package mjpeg
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DecoderStats is a snapshot of Decoder counters
+type DecoderStats struct {
+	// Frames is the number of frames returned by the decoder.
+	Frames uint64
+	// Skipped is the number of frames dropped by the decoder itself.
+	Skipped uint64
+	// Errors is the number of read and JPEG decode errors.
+	Errors uint64
+	// Corrupt is the number of parts skipped by frame validation.
+	Corrupt uint64
+	// Bytes is the number of frame bytes read.
+	Bytes uint64
+	// Reconnects is the number of times a reconnecting decoder connected
+	// again after the stream broke.
+	Reconnects uint64
+	// FPS and Bitrate (bits per second) are averaged over the last few seconds.
+	FPS     float64
+	Bitrate float64
+}
+
+type decoderStats struct {
+	frames  atomic.Uint64
+	skipped atomic.Uint64
+	errors  atomic.Uint64
+	corrupt atomic.Uint64
+	bytes   atomic.Uint64
+	rate    rateMeter
+
+	reconnects atomic.Uint64
+}
+
+func (s *decoderStats) frame(p *part) {
+	s.frames.Add(1)
+	s.skipped.Add(uint64(p.skipped))
+	s.bytes.Add(uint64(len(p.data)))
+	s.rate.add(time.Now(), len(p.data))
+}
+
+// Stats return counters of the decoder
+func (d *Decoder) Stats() DecoderStats {
+	fps, bps := d.stats.rate.rate(time.Now())
+	return DecoderStats{
+		Frames:  d.stats.frames.Load(),
+		Skipped: d.stats.skipped.Load(),
+		Errors:  d.stats.errors.Load(),
+		Corrupt: d.stats.corrupt.Load(),
+		Bytes:   d.stats.bytes.Load(),
+		FPS:     fps,
+		Bitrate: bps * 8,
+
+		Reconnects: d.stats.reconnects.Load(),
+	}
+}
+
+// StreamStats is a snapshot of Stream counters
+type StreamStats struct {
+	// Clients is the number of ServeHTTP viewers and subscriptions.
+	Clients int
+	// Published is the number of frames sent to the clients.
+	Published uint64
+	// Dropped is the number of frames not delivered to a client, summed over
+	// the clients since the creation of the stream.
+	Dropped uint64
+	// Bytes is the number of frame bytes written to the viewers.
+	Bytes uint64
+	// Errors is the number of failed writes to the viewers.
+	Errors uint64
+}
+
+type streamStats struct {
+	published atomic.Uint64
+	dropped   atomic.Uint64
+	bytes     atomic.Uint64
+	errors    atomic.Uint64
+}
+
+// Stats return counters of the stream
+func (s *Stream) Stats() StreamStats {
+	return StreamStats{
+		Clients:   s.nclients(),
+		Published: s.stats.published.Load(),
+		Dropped:   s.stats.dropped.Load(),
+		Bytes:     s.stats.bytes.Load(),
+		Errors:    s.stats.errors.Load(),
+	}
+}
+
+const rateWindow = 5
+
+// rateMeter counts events and bytes in one second buckets over the last rateWindow seconds.
+type rateMeter struct {
+	mu      sync.Mutex
+	buckets [rateWindow + 1]rateBucket
+}
+
+type rateBucket struct {
+	sec   int64
+	n     uint64
+	bytes uint64
+}
+
+func (m *rateMeter) add(now time.Time, n int) {
+	sec := now.Unix()
+	m.mu.Lock()
+	b := &m.buckets[sec%int64(len(m.buckets))]
+	if b.sec != sec {
+		*b = rateBucket{sec: sec}
+	}
+	b.n++
+	b.bytes += uint64(n)
+	m.mu.Unlock()
+}
+
+// rate returns events and bytes per second over the completed seconds of the window.
+func (m *rateMeter) rate(now time.Time) (float64, float64) {
+	sec := now.Unix()
+	var n, bytes uint64
+	m.mu.Lock()
+	for _, b := range m.buckets {
+		if b.sec < sec && b.sec >= sec-rateWindow {
+			n += b.n
+			bytes += b.bytes
+		}
+	}
+	m.mu.Unlock()
+	return float64(n) / rateWindow, float64(bytes) / rateWindow
+}
@@ -0,0 +1,1147 @@
+package mjpeg
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"hash/maphash"
+	"image"
+	"io"
+	"log/slog"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrStreamClosed is returned when using a closed Stream.
+var ErrStreamClosed = errors.New("stream was closed")
+
+// ErrUnknownWatcher is returned by Disconnect when no viewer has the ID.
+var ErrUnknownWatcher = errors.New("unknown watcher")
+
+// DropPolicy tells what Update does when the buffer of a client is full
+type DropPolicy int
+
+const (
+	// DropNewest discards the new frame for that client.
+	DropNewest DropPolicy = iota
+	// DropOldest discards the oldest buffered frame to make room for the new one.
+	DropOldest
+	// Block makes Update wait until the client has room, or is gone.
+	Block
+)
+
+// StreamOption configure Stream
+type StreamOption func(*Stream)
+
+// WithClientBuffer set how many frames are buffered per client. The default
+// is 0, a frame reaches only clients which are waiting for it.
+func WithClientBuffer(n int) StreamOption {
+	return func(s *Stream) {
+		s.bufSize = n
+	}
+}
+
+// WithDropPolicy set what happens to frames of a client whose buffer is full.
+// The default is DropNewest.
+func WithDropPolicy(p DropPolicy) StreamOption {
+	return func(s *Stream) {
+		s.policy = p
+	}
+}
+
+// WithMaxPublishRate limit the rate of frames sent to the clients. Frames of
+// a burst of Update are coalesced, keeping the newest one.
+func WithMaxPublishRate(fps float64) StreamOption {
+	return func(s *Stream) {
+		if fps > 0 {
+			s.publishInterval = time.Duration(float64(time.Second) / fps)
+		}
+	}
+}
+
+// WithClientMaxFPS limit the rate of frames sent to each client by ServeHTTP.
+// Frames in excess are dropped rather than delayed. A client can ask for a
+// lower rate with the fps query parameter, e.g. /mjpeg?fps=2 for thumbnails.
+func WithClientMaxFPS(fps float64) StreamOption {
+	return func(s *Stream) {
+		s.maxFPS = fps
+	}
+}
+
+// WithMaxClients limit the number of clients served at once by ServeHTTP.
+// Clients in excess get a 503 Service Unavailable response with a Retry-After
+// header. Zero means no limit.
+func WithMaxClients(n int) StreamOption {
+	return func(s *Stream) {
+		s.maxClients = n
+	}
+}
+
+// WatcherFunc is called by ServeHTTP with the request of a viewer.
+type WatcherFunc func(r *http.Request, id WatcherID)
+
+// WithOnConnect sets f to be called when a viewer attaches, before the first
+// frame is sent.
+func WithOnConnect(f WatcherFunc) StreamOption {
+	return func(s *Stream) {
+		s.onConnect = f
+	}
+}
+
+// WithOnDisconnect sets f to be called when a viewer is gone.
+func WithOnDisconnect(f WatcherFunc) StreamOption {
+	return func(s *Stream) {
+		s.onDisconnect = f
+	}
+}
+
+// TimestampFormat tells how ServeHTTP writes the X-StartTime and X-TimeStamp
+// headers of the parts.
+type TimestampFormat int
+
+const (
+	// TimestampUnix writes Unix seconds, it is the default.
+	TimestampUnix TimestampFormat = iota
+	// TimestampUnixMilli writes Unix milliseconds.
+	TimestampUnixMilli
+	// TimestampRFC3339Nano writes times as time.RFC3339Nano.
+	TimestampRFC3339Nano
+	// TimestampMonotonic writes the seconds elapsed since the client attached,
+	// with a fractional part, so X-StartTime is always 0.
+	TimestampMonotonic
+	// TimestampNone leaves the headers out.
+	TimestampNone
+)
+
+// WithTimestampFormat sets the format of the timestamp headers.
+func WithTimestampFormat(f TimestampFormat) StreamOption {
+	return func(s *Stream) {
+		s.timestamps = f
+	}
+}
+
+// append appends t as written in the headers to b, start is the time when
+// the client attached.
+func (f TimestampFormat) append(b []byte, t, start time.Time) []byte {
+	switch f {
+	case TimestampUnixMilli:
+		return strconv.AppendInt(b, t.UnixMilli(), 10)
+	case TimestampRFC3339Nano:
+		return t.AppendFormat(b, time.RFC3339Nano)
+	case TimestampMonotonic:
+		return strconv.AppendFloat(b, t.Sub(start).Seconds(), 'f', 3, 64)
+	default:
+		return strconv.AppendInt(b, t.Unix(), 10)
+	}
+}
+
+// WithBoundary sets the multipart boundary of ServeHTTP instead of a random
+// one, for viewers which only accept a given boundary, such as "myboundary".
+// An invalid boundary is ignored.
+func WithBoundary(b string) StreamOption {
+	return func(s *Stream) {
+		s.boundary = b
+	}
+}
+
+// WithFastWriter selects how ServeHTTP writes the parts. Disabled, the
+// default, the parts go through multipart.Writer, which allocates per part
+// and writes the headers in sorted order. Enabled, the headers are rendered
+// into a reused buffer and each part is written at once: BenchmarkWritePart
+// writes a part in about 0.4µs without allocating, against 4µs and 38
+// allocations for multipart.Writer.
+func WithFastWriter(enabled bool) StreamOption {
+	return func(s *Stream) {
+		s.fastWriter = enabled
+	}
+}
+
+// WithHeartbeat makes ServeHTTP send the last frame again when no frame was
+// sent to a client for d, so that proxies and browsers do not close idle
+// streams.
+func WithHeartbeat(d time.Duration) StreamOption {
+	return func(s *Stream) {
+		s.heartbeat = d
+	}
+}
+
+// ClientError is a failure to write to a viewer of ServeHTTP.
+type ClientError struct {
+	ID   WatcherID
+	Err  error
+	Time time.Time
+}
+
+func (e ClientError) Error() string {
+	return fmt.Sprintf("watcher %d: %s", e.ID, e.Err)
+}
+
+func (e ClientError) Unwrap() error {
+	return e.Err
+}
+
+// errorsBuffer is the number of ClientError kept until they are received.
+const errorsBuffer = 16
+
+// Errors returns the channel receiving the write failures of the viewers.
+// Errors are dropped when the channel is full, it is never closed.
+func (s *Stream) Errors() <-chan ClientError {
+	return s.errs
+}
+
+func (s *Stream) reportError(id WatcherID, err error) {
+	s.stats.errors.Add(1)
+	select {
+	case s.errs <- ClientError{ID: id, Err: err, Time: time.Now()}:
+	default:
+	}
+}
+
+// WithAdaptiveRate reduces the rate of frames sent to a viewer whose
+// connection can not keep up, from the time taken by its writes. The
+// frames which would not fit are dropped, the newest frame is sent. The
+// resulting rate is reported by Watchers.
+func WithAdaptiveRate() StreamOption {
+	return func(s *Stream) {
+		s.adaptive = true
+	}
+}
+
+// retryAfter is the delay advertised to clients refused by WithMaxClients.
+const retryAfter = 5 * time.Second
+
+type Stream struct {
+	// m guards the state of the stream, pubM orders the deliveries of frames.
+	m    sync.Mutex
+	pubM sync.Mutex
+	// shards hold the clients, see shard, delivering frames does not hold m.
+	shards    []*shard
+	nshards   int
+	nextShard int
+	closed    bool
+	// shutdown refuses new viewers, handlers counts those served.
+	shutdown bool
+	handlers sync.WaitGroup
+	endFrame []byte
+	// stopCtx unbinds the context of NewStreamContext.
+	stopCtx func() bool
+	// Interval is the minimum time between frames sent to a client, frames
+	// arriving in between are dropped. WithClientMaxFPS takes precedence.
+	Interval time.Duration
+
+	bufSize int
+	policy  DropPolicy
+	maxFPS  float64
+
+	maxClients int
+	// viewers is the number of clients served by ServeHTTP.
+	viewers int
+	nextID  atomic.Uint64
+	// byID holds the ServeHTTP clients by WatcherID. It is apart from s.m,
+	// which a blocked Update holds, so that Watchers and Disconnect can
+	// always proceed.
+	byID sync.Map
+
+	// widths, qualities, clientOverlay and regions are the variants the
+	// viewers can ask for.
+	widths        []int
+	qualities     map[string]int
+	clientOverlay *Overlay
+	regions       map[string]image.Rectangle
+	// orientation, crop, overlay and watermark are applied to the frames
+	// when they are published.
+	orientation Orientation
+	crop        image.Rectangle
+	overlay     *Overlay
+	watermark   *Watermark
+	// transformers are run after them, pipeline runs them all.
+	transformers []FrameTransformer
+	pipeline     pipeline
+
+	timestamps TimestampFormat
+	origins    []string
+	boundary   string
+	fastWriter bool
+	mp4Entry   string
+	heartbeat  time.Duration
+
+	// updated is the time of the last frame, or of the creation of the
+	// stream, for WithPlaceholder.
+	updated    time.Time
+	staleAfter time.Duration
+	noSignal   []byte
+	phM        sync.Mutex
+	ph         *Frame
+
+	errs  chan ClientError
+	stats streamStats
+	log   Logger
+	slog  *slog.Logger
+	name  string
+
+	// bufs holds the buffers of FrameBuffer.
+	bufs sync.Pool
+
+	paused      bool
+	pausedFrame []byte
+
+	publishInterval time.Duration
+	broadcasted     time.Time
+	coalesced       *Frame
+	publishTimer    *time.Timer
+
+	validate  bool
+	bandwidth int
+	adaptive  bool
+
+	dedup    bool
+	dedupMax time.Duration
+	seed     maphash.Seed
+	lastHash uint64
+
+	healthMaxAge    time.Duration
+	sourceConnected func() bool
+
+	onConnect    WatcherFunc
+	onDisconnect WatcherFunc
+	sessions     SessionFunc
+
+	// encM guards the encoding buffer and settings of UpdateImage.
+	encM    sync.Mutex
+	enc     bytes.Buffer
+	quality int
+	encoder Codec
+
+	// seq is the sequence number of the last published frame.
+	seq uint64
+	// last is the most recent frame, sent to clients when they attach.
+	last *Frame
+}
+
+// client is a subscriber of the stream.
+type client struct {
+	c chan *Frame
+	// first is the cached frame at the time the client attached.
+	first *Frame
+	// done is closed when the client is closed, it releases a blocked Update.
+	done chan struct{}
+	// m guards c against close while a frame is sent, once closes it.
+	m       sync.Mutex
+	closed  bool
+	once    sync.Once
+	policy  DropPolicy
+	dropped atomic.Uint64
+	detach  bool
+	shard   *shard
+	// stats is the counters of the stream, which the client adds to.
+	stats *streamStats
+
+	// The fields below are only set for the clients of ServeHTTP.
+	id     WatcherID
+	kick   chan struct{}
+	kicked sync.Once
+	remote string
+	since  time.Time
+	frames atomic.Uint64
+	bytes  atomic.Uint64
+	rate   rateMeter
+	// writeTime is the moving average of write durations, in nanoseconds.
+	writeTime atomic.Int64
+	interval  time.Duration
+	// end is the frame of Shutdown, written after the last one of c.
+	end *Frame
+}
+
+// WatcherID identifies a client of ServeHTTP during the life of the Stream.
+type WatcherID uint64
+
+// WatcherInfo describe a client of ServeHTTP.
+type WatcherInfo struct {
+	ID         WatcherID
+	RemoteAddr string
+	Connected  time.Time
+	// Frames and Bytes count what was written to the client.
+	Frames uint64
+	Bytes  uint64
+	// Dropped is the number of frames the client did not get, because it
+	// was lagging or because of its frame rate.
+	Dropped uint64
+	// FPS is the rate of frames delivered over the last seconds.
+	FPS float64
+	// WriteTime is the average time taken to write a frame, which tells
+	// whose connection can not keep up.
+	WriteTime time.Duration
+	// Interval is the minimum time between the frames of the client, from
+	// its fps query parameter or WithClientMaxFPS, 0 for every frame.
+	Interval time.Duration
+}
+
+func NewStream(opts ...StreamOption) *Stream {
+	s := &Stream{
+		updated: time.Now(),
+		errs:    make(chan ClientError, errorsBuffer),
+		log:     nopLogger{},
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.shards = newShards(s.nshards)
+	s.pipeline = s.newPipeline()
+	return s
+}
+
+// NewStreamContext return new instance of Stream which is closed, with its
+// viewers and subscriptions, when ctx is done.
+func NewStreamContext(ctx context.Context, opts ...StreamOption) *Stream {
+	s := NewStream(opts...)
+	// s.m orders an immediate Close after the assignment.
+	s.m.Lock()
+	s.stopCtx = context.AfterFunc(ctx, func() {
+		s.Close()
+	})
+	s.m.Unlock()
+	return s
+}
+
+func NewStreamWithInterval(interval time.Duration, opts ...StreamOption) *Stream {
+	s := NewStream(opts...)
+	s.Interval = interval
+	return s
+}
+
+// Close ends the responses of the viewers and closes the subscriptions.
+// Frames are refused from then on. Closing a closed stream does nothing.
+func (s *Stream) Close() error {
+	s.m.Lock()
+	defer s.m.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.logAt(context.Background(), slog.LevelWarn, "closing stream")
+	s.closed = true
+	if s.stopCtx != nil {
+		s.stopCtx()
+	}
+	s.closeClients()
+	return nil
+}
+
+// Reset detaches all clients, like Close, and makes the stream ready for new
+// ones, without the cached frame of the previous source.
+func (s *Stream) Reset() {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.closeClients()
+	s.closed = false
+	s.shutdown = false
+	s.last.release()
+	s.last = nil
+	s.coalesced.release()
+	s.coalesced = nil
+	s.updated = time.Now()
+}
+
+// closeClients is called with s.m held.
+func (s *Stream) closeClients() {
+	for _, sh := range s.shards {
+		for _, c := range sh.clients() {
+			c.close()
+		}
+		sh.subs.Store(&[]*client{})
+	}
+}
+
+func (s *Stream) Update(b []byte) error {
+	return s.UpdateWithHeaders(b, nil)
+}
+
+// UpdateWithHeaders is like Update and adds h to the part carrying the frame,
+// e.g. a camera ID or detection results. The headers of the part itself,
+// such as Content-Type and Content-Length, can not be replaced.
+func (s *Stream) UpdateWithHeaders(b []byte, h textproto.MIMEHeader) error {
+	return s.publish(&Frame{Data: b, Header: h, Time: time.Now()})
+}
+
+// UpdateTyped is like Update for a frame of another image type, e.g.
+// image/png for lossless screen captures.
+func (s *Stream) UpdateTyped(b []byte, contentType string) error {
+	return s.publish(&Frame{Data: b, Time: time.Now(), contentType: contentType})
+}
+
+func (s *Stream) publish(f *Frame) error {
+	if s.validate && isJPEGType(f.contentType) && !validJPEG(f.Data) {
+		return &InvalidFrameError{Size: len(f.Data)}
+	}
+	var h uint64
+	if s.dedup {
+		// The duplicates are skipped before the transforms are paid for.
+		h = maphash.Bytes(s.seed, f.Data)
+		s.m.Lock()
+		dup := !s.closed && !s.paused && s.duplicate(h, f.Time)
+		if dup {
+			// The source is alive even if the scene is static.
+			s.touch(f)
+		}
+		s.m.Unlock()
+		if dup {
+			return nil
+		}
+	}
+	if len(s.pipeline.ts) > 0 {
+		outage := f.outage
+		var err error
+		if f, err = s.pipeline.run(f); err != nil || f == nil {
+			return err
+		}
+		f.outage = outage
+	}
+
+	s.pubM.Lock()
+	defer s.pubM.Unlock()
+	s.m.Lock()
+	if s.closed {
+		s.m.Unlock()
+		return ErrStreamClosed
+	}
+	if s.paused {
+		s.m.Unlock()
+		return nil
+	}
+	if s.dedup {
+		// A concurrent publish may have sent it meanwhile.
+		if s.duplicate(h, f.Time) {
+			s.touch(f)
+			s.m.Unlock()
+			return nil
+		}
+		s.lastHash = h
+	}
+	if s.publishInterval > 0 {
+		if wait := s.publishInterval - time.Since(s.broadcasted); wait > 0 {
+			// Only the newest frame of a burst is sent, at the next slot.
+			s.coalesced.release()
+			f.retain()
+			s.coalesced = f
+			s.touch(f)
+			if s.publishTimer == nil {
+				s.publishTimer = time.AfterFunc(wait, s.flushCoalesced)
+			}
+			s.m.Unlock()
+			return nil
+		}
+	}
+	snap := s.broadcast(f)
+	s.m.Unlock()
+
+	fanout(snap, f)
+	return nil
+}
+
+// broadcast makes f the current frame and returns the clients to send it to,
+// a client coming later gets it as its first frame. It is called with s.m
+// held.
+func (s *Stream) broadcast(f *Frame) [][]*client {
+	s.seq++
+	s.stats.published.Add(1)
+	f.Seq = s.seq
+	f.retain()
+	s.last.release()
+	s.last = f
+	s.touch(f)
+	s.broadcasted = time.Now()
+	return s.snapshot()
+}
+
+// touch records the time of f as the last sign of life of the source, which
+// an outage frame is not. It is called with s.m held.
+func (s *Stream) touch(f *Frame) {
+	if !f.outage {
+		s.updated = f.Time
+	}
+}
+
+// flushCoalesced sends the frame kept by WithMaxPublishRate.
+func (s *Stream) flushCoalesced() {
+	s.pubM.Lock()
+	defer s.pubM.Unlock()
+	s.m.Lock()
+	f := s.coalesced
+	s.coalesced = nil
+	s.publishTimer = nil
+	defer f.release()
+	if f == nil || s.closed || s.paused {
+		s.m.Unlock()
+		return
+	}
+	snap := s.broadcast(f)
+	s.m.Unlock()
+
+	fanout(snap, f)
+}
+
+// send delivers f to c according to its drop policy. A frame in the channel
+// holds a reference, which goes to the receiver.
+func (c *client) send(f *Frame) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	if c.closed {
+		return
+	}
+	if c.detach {
+		f = f.detached()
+	}
+	f.retain()
+	select {
+	case c.c <- f:
+		return
+	default:
+	}
+
+	switch c.policy {
+	case DropOldest:
+		select {
+		case old := <-c.c:
+			old.release()
+			c.drop()
+		default:
+		}
+		select {
+		case c.c <- f:
+		default:
+			f.release()
+			c.drop()
+		}
+	case Block:
+		select {
+		case c.c <- f:
+		case <-c.done:
+			f.release()
+		case <-c.kick:
+			f.release()
+		}
+	default:
+		f.release()
+		c.drop()
+	}
+}
+
+// add registers a client. The frames of a detached client are copies out of
+// the reference counting of pooled frames, for consumers outside the package.
+func (s *Stream) add(bufSize int, policy DropPolicy, detach bool) *client {
+	c := &client{
+		c:      make(chan *Frame, bufSize),
+		done:   make(chan struct{}),
+		kick:   make(chan struct{}),
+		policy: policy,
+		detach: detach,
+		stats:  &s.stats,
+	}
+	s.m.Lock()
+	if s.closed {
+		// Closed stream, the handler sees the closed channel.
+		c.close()
+	} else {
+		if detach && bufSize > 0 {
+			// No frame was sent to c yet, the channel has room.
+			if s.last != nil {
+				c.c <- s.last.detached()
+			}
+		} else if detach {
+			c.first = s.last.detached()
+		} else {
+			c.first = s.last
+			c.first.retain()
+		}
+		c.shard = s.shards[s.nextShard%len(s.shards)]
+		s.nextShard++
+		c.shard.add(c)
+	}
+	s.m.Unlock()
+	return c
+}
+
+func (s *Stream) destroy(c *client) {
+	if c.shard != nil {
+		s.m.Lock()
+		c.shard.remove(c)
+		s.m.Unlock()
+	}
+	c.close()
+
+	// The channel is closed, release what is left.
+	c.first.release()
+	c.first = nil
+	for f := range c.c {
+		f.release()
+	}
+}
+
+// NWatch returns the number of clients, ServeHTTP viewers and subscriptions.
+//
+// Deprecated: use Watchers, which also tells who is watching.
+func (s *Stream) NWatch() int {
+	return s.nclients()
+}
+
+func (s *Stream) nclients() int {
+	n := 0
+	for _, sh := range s.shards {
+		n += len(sh.clients())
+	}
+	return n
+}
+
+// drop counts a frame not delivered to c.
+func (c *client) drop() {
+	c.dropped.Add(1)
+	c.stats.dropped.Add(1)
+}
+
+// close stops the deliveries to c and closes its channel. done is closed first
+// to release a blocked send.
+func (c *client) close() {
+	c.once.Do(func() {
+		close(c.done)
+		c.m.Lock()
+		c.closed = true
+		close(c.c)
+		c.m.Unlock()
+	})
+}
+
+// Watchers returns the clients currently served by ServeHTTP.
+func (s *Stream) Watchers() []WatcherInfo {
+	var w []WatcherInfo
+	now := time.Now()
+	s.byID.Range(func(_, v any) bool {
+		c := v.(*client)
+		fps, _ := c.rate.rate(now)
+		w = append(w, WatcherInfo{
+			ID:         c.id,
+			RemoteAddr: c.remote,
+			Connected:  c.since,
+			Frames:     c.frames.Load(),
+			Bytes:      c.bytes.Load(),
+			Dropped:    c.dropped.Load(),
+			FPS:        fps,
+			WriteTime:  time.Duration(c.writeTime.Load()),
+			Interval:   c.interval,
+		})
+		return true
+	})
+	sort.Slice(w, func(i, j int) bool { return w[i].Connected.Before(w[j].Connected) })
+	return w
+}
+
+// Current return the most recent frame, or waits for the next Update when
+// there was none yet.
+func (s *Stream) Current() []byte {
+	b, _ := s.CurrentContext(context.Background())
+	return b
+}
+
+// CurrentContext is like Current but gives up waiting when ctx is done,
+// returning ctx.Err(). It returns nil and no error when the stream is closed
+// before any frame.
+func (s *Stream) CurrentContext(ctx context.Context) ([]byte, error) {
+	f, err := s.currentFrame(ctx)
+	if f == nil {
+		return nil, err
+	}
+	return f.Data, nil
+}
+
+func (s *Stream) currentFrame(ctx context.Context) (*Frame, error) {
+	s.m.Lock()
+	f := s.last.detached()
+	s.m.Unlock()
+	if f != nil {
+		return f, nil
+	}
+
+	c := s.add(0, DropNewest, true)
+	defer s.destroy(c)
+	if c.first != nil {
+		return c.first, nil
+	}
+	select {
+	case f := <-c.c:
+		return f, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// TryCurrent return the most recent frame without waiting, and false when
+// there was none yet.
+func (s *Stream) TryCurrent() ([]byte, bool) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	if s.last == nil {
+		return nil, false
+	}
+	return s.last.detached().Data, true
+}
+
+func (s *Stream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.serve(w, r, s.openMultipart)
+}
+
+// viewerConn is the connection to a viewer of serve.
+type viewerConn interface {
+	partsWriter
+	flush() error
+	setWriteDeadline(t time.Time) error
+}
+
+// opener starts the response to a viewer admitted by serve. It returns the
+// context of the connection, or an error once it answered r.
+type opener func(w http.ResponseWriter, r *http.Request, c *client) (viewerConn, context.Context, error)
+
+// serve sends the frames to a viewer through the connection made by open,
+// with the policies of the stream.
+func (s *Stream) serve(w http.ResponseWriter, r *http.Request, open opener) {
+	if s.cors(w, r) {
+		return
+	}
+	if !s.enter() {
+		serviceUnavailable(w)
+		return
+	}
+	defer s.handlers.Done()
+	if !s.acquire() {
+		s.logAt(r.Context(), slog.LevelWarn, "too many clients", "remote", r.RemoteAddr)
+		serviceUnavailable(w)
+		return
+	}
+	defer s.release()
+
+	c := s.add(s.bufSize, s.policy, false)
+	c.id = WatcherID(s.nextID.Add(1))
+	c.remote = r.RemoteAddr
+	c.since = time.Now()
+	c.interval = s.clientInterval(r)
+	s.byID.Store(c.id, c)
+	if s.onConnect != nil {
+		s.onConnect(r, c.id)
+	}
+	sess := s.startSession(r, c)
+	defer func() {
+		s.byID.Delete(c.id)
+		s.destroy(c)
+		sess.end()
+		if s.onDisconnect != nil {
+			s.onDisconnect(r, c.id)
+		}
+	}()
+
+	pw, ctx, err := open(w, r, c)
+	if err != nil {
+		s.logAt(r.Context(), slog.LevelWarn, "connection failed", "watcher", c.id, "remote", c.remote, "error", err)
+		return
+	}
+	defer pw.release()
+
+	// A disconnected client may be stuck writing, the write deadline
+	// releases it.
+	exited := make(chan struct{})
+	defer close(exited)
+	go func() {
+		select {
+		case <-c.kick:
+			pw.setWriteDeadline(time.Now())
+		case <-exited:
+		}
+	}()
+
+	// last is the time when sent was written, the heartbeat writes it again
+	// when the stream is idle.
+	var last time.Time
+	var sent *Frame
+	var beat *time.Timer
+	var heartbeat <-chan time.Time
+	if s.heartbeat > 0 {
+		beat = time.NewTimer(s.heartbeat)
+		defer beat.Stop()
+		heartbeat = beat.C
+	}
+
+	var bucket *tokenBucket
+	if s.bandwidth > 0 {
+		bucket = newTokenBucket(s.bandwidth)
+	}
+
+	// vk is the variant of the frames asked by the client.
+	vk := s.clientVariant(r)
+
+	// send takes a reference of f, which is kept in sent on success.
+	send := func(f *Frame) error {
+		sess.drops()
+		if bucket != nil && !bucket.allow(len(f.Data)) {
+			f.release()
+			c.drop()
+			return nil
+		}
+		begin := time.Now()
+		n, err := pw.writePart(f, begin)
+		c.bytes.Add(uint64(n))
+		s.stats.bytes.Add(uint64(n))
+		if err == nil {
+			c.frames.Add(1)
+			err = pw.flush()
+		}
+		if err != nil {
+			s.logAt(ctx, slog.LevelError, "write failed", "watcher", c.id, "remote", c.remote, "size", len(f.Data), "error", err)
+			s.reportError(c.id, err)
+			sess.error(err)
+			f.release()
+			return err
+		}
+		last = time.Now()
+		sent.release()
+		sent = f
+		c.rate.add(last, n)
+		c.writeTime.Store((c.writeTime.Load()*4 + int64(last.Sub(begin))) / 5)
+		if beat != nil {
+			beat.Reset(s.heartbeat)
+		}
+		return nil
+	}
+	// write sends the variant of f, sent is already one.
+	write := func(f *Frame) error {
+		if v := s.variant(f, vk); v != f {
+			f.release()
+			f = v
+		}
+		return send(f)
+	}
+
+	// The placeholder is sent when no frame came for the stale window.
+	var staleT *time.Timer
+	var stale <-chan time.Time
+	if s.staleAfter > 0 {
+		s.m.Lock()
+		wait := s.staleAfter - time.Since(s.updated)
+		s.m.Unlock()
+		staleT = time.NewTimer(wait)
+		defer staleT.Stop()
+		stale = staleT.C
+	}
+
+	// Frames arriving faster than the client rate are not delayed but
+	// replace the one waiting for the next slot.
+	interval := c.interval
+	var pending *Frame
+	var slot <-chan time.Time
+	defer func() {
+		sent.release()
+		pending.release()
+	}()
+
+	// The cached frame is sent right away so that the viewer renders it
+	// without waiting for the next Update.
+	if f := c.first; f != nil {
+		c.first = nil
+		if write(f) != nil {
+			return
+		}
+	}
+
+loop:
+	for {
+		select {
+		case f, ok := <-c.c:
+			if !ok {
+				// The stream was closed, end the response with the closing
+				// boundary after the frame kept for the interval and the
+				// one of Shutdown.
+				s.logAt(ctx, slog.LevelDebug, "stream closed", "watcher", c.id, "remote", c.remote)
+				if f := pending; f != nil {
+					pending = nil
+					write(f)
+				}
+				if f := c.end; f != nil {
+					c.end = nil
+					write(f)
+				}
+				break loop
+			}
+			if staleT != nil {
+				staleT.Reset(s.staleAfter)
+			}
+			interval := interval
+			if s.adaptive {
+				// Leave a margin above the write time so that the socket
+				// drains, and drop the frames which would not fit.
+				interval = max(interval, time.Duration(c.writeTime.Load())*5/4)
+			}
+			if wait := interval - time.Since(last); interval > 0 && wait > 0 {
+				if pending != nil {
+					pending.release()
+					c.drop()
+				}
+				pending = f
+				if slot == nil {
+					slot = time.After(wait)
+				}
+				continue
+			}
+			if write(f) != nil {
+				break loop // Stop and close if the writer is not available any more
+			}
+		case <-slot:
+			slot = nil
+			f := pending
+			pending = nil
+			if write(f) != nil {
+				break loop
+			}
+		case now := <-stale:
+			// Duplicates are not sent but keep the source fresh.
+			if !s.stale(now) {
+				s.m.Lock()
+				wait := s.updated.Add(s.staleAfter).Sub(now)
+				s.m.Unlock()
+				staleT.Reset(wait)
+				continue
+			}
+			staleT.Reset(s.staleAfter)
+			if s.Paused() {
+				continue
+			}
+			if f := s.placeholder(now); f != nil {
+				if write(f) != nil {
+					break loop
+				}
+			}
+		case <-heartbeat:
+			if sent == nil {
+				beat.Reset(s.heartbeat)
+				continue
+			}
+			sent.retain()
+			if send(sent) != nil {
+				break loop
+			}
+		case <-ctx.Done():
+			s.logAt(ctx, slog.LevelDebug, "client gone", "watcher", c.id, "remote", c.remote)
+			return
+		case <-c.kick:
+			s.logAt(ctx, slog.LevelDebug, "client disconnected", "watcher", c.id, "remote", c.remote)
+			return
+		}
+	}
+
+	pw.close()
+	pw.flush()
+	s.logAt(ctx, slog.LevelDebug, "exiting stream", "watcher", c.id, "remote", c.remote, "frames", c.frames.Load())
+}
+
+// openMultipart starts the multipart/x-mixed-replace response of ServeHTTP.
+func (s *Stream) openMultipart(w http.ResponseWriter, r *http.Request, c *client) (viewerConn, context.Context, error) {
+	// multipart.Writer makes or checks the boundary, partWriter writes
+	// when enabled by WithFastWriter.
+	m := multipart.NewWriter(w)
+	if s.fastWriter {
+		m = multipart.NewWriter(io.Discard)
+	}
+	if s.boundary != "" {
+		if err := m.SetBoundary(s.boundary); err != nil {
+			s.logAt(r.Context(), slog.LevelError, "invalid boundary", "boundary", s.boundary, "error", err)
+		}
+	}
+	w.Header().Set("Content-Type", mime.FormatMediaType("multipart/x-mixed-replace", map[string]string{"boundary": m.Boundary()}))
+	// HTTP/2 and HTTP/3 forbid connection-specific headers, the stream ends
+	// there with the request.
+	if r.ProtoMajor == 1 {
+		w.Header().Set("Connection", "close")
+	}
+	conn := &multipartConn{rc: http.NewResponseController(w)}
+	if s.fastWriter {
+		conn.partsWriter = newPartWriter(w, m.Boundary(), s.timestamps, c.since)
+	} else {
+		conn.partsWriter = &multipartWriter{m: m, format: s.timestamps, start: c.since}
+	}
+	return conn, r.Context(), nil
+}
+
+// multipartConn is the response of ServeHTTP. rc reaches Flush and
+// SetWriteDeadline through wrappers of the ResponseWriter, and works the
+// same over HTTP/1.1, HTTP/2 and HTTP/3.
+type multipartConn struct {
+	partsWriter
+	rc *http.ResponseController
+}
+
+func (m *multipartConn) flush() error {
+	return flush(m.rc)
+}
+
+func (m *multipartConn) setWriteDeadline(t time.Time) error {
+	return m.rc.SetWriteDeadline(t)
+}
+
+// flush sends the buffered data to the client. Without chunked encoding, as
+// over HTTP/2 and HTTP/3, each frame reaches the client only once flushed.
+func flush(rc *http.ResponseController) error {
+	if err := rc.Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		return err
+	}
+	return nil
+}
+
+// Disconnect close the connection of the viewer id, without waiting for the
+// handler to return.
+func (s *Stream) Disconnect(id WatcherID) error {
+	v, ok := s.byID.Load(id)
+	if !ok {
+		return ErrUnknownWatcher
+	}
+	c := v.(*client)
+	c.kicked.Do(func() {
+		close(c.kick)
+	})
+	return nil
+}
+
+// acquire reserve a place for a ServeHTTP client, it returns false when
+// WithMaxClients is reached.
+func (s *Stream) acquire() bool {
+	s.m.Lock()
+	defer s.m.Unlock()
+	if s.maxClients > 0 && s.viewers >= s.maxClients {
+		return false
+	}
+	s.viewers++
+	return true
+}
+
+func (s *Stream) release() {
+	s.m.Lock()
+	s.viewers--
+	s.m.Unlock()
+}
+
+// clientInterval returns the minimum time between frames sent to the client
+// of r, from the fps query parameter, bounded by WithClientMaxFPS or
+// Interval.
+func (s *Stream) clientInterval(r *http.Request) time.Duration {
+	interval := s.Interval
+	if s.maxFPS > 0 {
+		interval = time.Duration(float64(time.Second) / s.maxFPS)
+	}
+	if v := r.URL.Query().Get("fps"); v != "" {
+		if f, err := strconv.ParseFloat(v, 64); err == nil && f > 0 {
+			interval = max(interval, time.Duration(float64(time.Second)/f))
+		}
+	}
+	return interval
+}
@@ -0,0 +1,68 @@
+package mjpeg
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// readUntil reads the chunks of c into buf until it contains want.
+func readUntil(t *testing.T, c <-chan []byte, buf *bytes.Buffer, want []byte) {
+	t.Helper()
+	for !bytes.Contains(buf.Bytes(), want) {
+		b, ok := <-c
+		if !ok {
+			t.Fatal("part not flushed before the response ended")
+		}
+		buf.Write(b)
+	}
+}
+
+func TestServeHTTP2(t *testing.T) {
+	s := NewStream()
+	defer s.Close()
+	srv := httptest.NewUnstartedServer(s)
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	defer srv.Close()
+
+	frames := [][]byte{gradientJPEG(t, 16, 16), gradientJPEG(t, 32, 16)}
+	s.Update(frames[0])
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+	res, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if res.ProtoMajor != 2 {
+		t.Fatalf("protocol %s, want HTTP/2", res.Proto)
+	}
+	if v, ok := res.Header["Connection"]; ok {
+		t.Errorf("Connection header %q sent over HTTP/2", v)
+	}
+
+	c := make(chan []byte)
+	go func() {
+		defer close(c)
+		for {
+			b := make([]byte, 32<<10)
+			n, err := res.Body.Read(b)
+			if n > 0 {
+				c <- b[:n]
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	// Each part is flushed without waiting for the next one.
+	var buf bytes.Buffer
+	readUntil(t, c, &buf, frames[0])
+	s.Update(frames[1])
+	readUntil(t, c, &buf, frames[1])
+}
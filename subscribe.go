@@ -0,0 +1,66 @@
+package mjpeg
+
+import (
+	"sync"
+)
+
+// SubscribeOption configure a Subscription
+type SubscribeOption func(*subscribeOptions)
+
+type subscribeOptions struct {
+	bufSize int
+	policy  DropPolicy
+}
+
+// WithSubscriptionBuffer set how many frames are buffered for the
+// subscription. The default is 1.
+func WithSubscriptionBuffer(n int) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.bufSize = n
+	}
+}
+
+// WithSubscriptionPolicy set what happens to frames when the buffer of the
+// subscription is full. The default is DropOldest, a slow consumer sees the
+// newest frames.
+func WithSubscriptionPolicy(p DropPolicy) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.policy = p
+	}
+}
+
+// Subscription delivers the frames of a Stream to an in-process consumer
+type Subscription struct {
+	// C receives the frames. It is closed by Close and when the stream is closed.
+	C <-chan *Frame
+
+	s    *Stream
+	c    *client
+	once sync.Once
+}
+
+// Subscribe attach a consumer to the stream. The most recent frame, if any,
+// is delivered first when the subscription has a buffer.
+func (s *Stream) Subscribe(opts ...SubscribeOption) (*Subscription, error) {
+	o := subscribeOptions{bufSize: 1, policy: DropOldest}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	c := s.add(o.bufSize, o.policy, true)
+	c.first = nil
+	select {
+	case <-c.done:
+		return nil, ErrStreamClosed
+	default:
+	}
+	return &Subscription{C: c.c, s: s, c: c}, nil
+}
+
+// Close detach the subscription from the stream and close C.
+func (sub *Subscription) Close() error {
+	sub.once.Do(func() {
+		sub.s.destroy(sub.c)
+	})
+	return nil
+}
@@ -0,0 +1,142 @@
+package mjpeg
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// RelayState is a state of the camera of a supervised relay
+type RelayState int
+
+const (
+	// RelayConnecting is the state during a connection attempt.
+	RelayConnecting RelayState = iota
+	// RelayStreaming is the state while the camera sends frames.
+	RelayStreaming
+	// RelayBackoff is the state while waiting before the next attempt.
+	RelayBackoff
+)
+
+func (s RelayState) String() string {
+	switch s {
+	case RelayConnecting:
+		return "connecting"
+	case RelayStreaming:
+		return "streaming"
+	case RelayBackoff:
+		return "backoff"
+	}
+	return fmt.Sprintf("RelayState(%d)", int(s))
+}
+
+// WithRelayDecoderOptions sets the options of the decoder made by
+// NewSupervisedRelay, such as WithBackoff or WithBasicAuth.
+func WithRelayDecoderOptions(opts ...DecoderOption) RelayOption {
+	return func(o *relayOptions) {
+		o.decoderOpts = append(o.decoderOpts, opts...)
+	}
+}
+
+// WithRelayOutageFrame makes a supervised relay publish b when the camera
+// is lost, and at every failed attempt after. When b is nil, a
+// "RECONNECTING" frame with the current time is generated, at the size of
+// the last frame. The outage frames do not count as frames of the camera for
+// WithHealth and WithPlaceholder.
+func WithRelayOutageFrame(b []byte) RelayOption {
+	return func(o *relayOptions) {
+		o.outage = true
+		o.outageFrame = b
+	}
+}
+
+// supervision is the state of a supervised relay.
+type supervision struct {
+	m     sync.Mutex
+	state RelayState
+	err   error
+}
+
+// NewSupervisedRelay return new instance of Relay from the camera at URL u
+// to s. The camera is connected again with backoff when it fails, State
+// tells how it goes. The decoder is closed when Run returns.
+func NewSupervisedRelay(u string, s *Stream, opts ...RelayOption) (*Relay, error) {
+	r := NewRelay(nil, s, opts...)
+	r.sup = &supervision{}
+	prev := newDecoderOptions(r.opts.decoderOpts).onStatus
+	dopts := append(r.opts.decoderOpts[:len(r.opts.decoderOpts):len(r.opts.decoderOpts)],
+		WithStatusFunc(func(st ConnStatus) {
+			r.status(st)
+			if prev != nil {
+				prev(st)
+			}
+		}))
+	d, err := NewReconnectingDecoder(u, dopts...)
+	if err != nil {
+		return nil, err
+	}
+	r.d = d
+	return r, nil
+}
+
+// State returns the state of the camera of a supervised relay and the error
+// of the last failure, RelayStreaming for a relay of NewRelay.
+func (r *Relay) State() (RelayState, error) {
+	if r.sup == nil {
+		return RelayStreaming, nil
+	}
+	r.sup.m.Lock()
+	defer r.sup.m.Unlock()
+	return r.sup.state, r.sup.err
+}
+
+func (r *Relay) status(st ConnStatus) {
+	r.sup.m.Lock()
+	switch st.State {
+	case Connecting:
+		r.sup.state = RelayConnecting
+	case Connected:
+		r.sup.state = RelayStreaming
+	case Disconnected:
+		r.sup.state = RelayBackoff
+		if st.Err != nil {
+			r.sup.err = st.Err
+		}
+	}
+	r.sup.m.Unlock()
+
+	if st.State == Disconnected && r.opts.outage {
+		if f := r.outageFrame(); f != nil {
+			r.s.publish(f)
+		}
+	}
+}
+
+// outageFrame returns the frame published during outages, nil if none can
+// be made.
+func (r *Relay) outageFrame() *Frame {
+	now := time.Now()
+	if r.opts.outageFrame != nil {
+		return &Frame{Data: r.opts.outageFrame, Time: now, outage: true}
+	}
+	w, h := r.s.frameSize()
+	var buf bytes.Buffer
+	if err := DefaultCodec.Encode(&buf, textImage(w, h, "RECONNECTING", now), nil); err != nil {
+		return nil
+	}
+	return &Frame{Data: buf.Bytes(), Time: now, outage: true}
+}
+
+// runSupervised runs the relay and closes the decoder it made. The decoder
+// is closed as soon as ctx is done, to stop a backoff or a connection
+// attempt.
+func (r *Relay) runSupervised(ctx context.Context) error {
+	stop := context.AfterFunc(ctx, func() {
+		r.d.Close()
+	})
+	defer stop()
+	defer r.d.Close()
+	return r.run(ctx)
+}
@@ -0,0 +1,93 @@
+package mjpeg
+
+import (
+	"sync"
+)
+
+// FrameSink consumes the frames of a Stream fed by Tee, e.g. a recorder or
+// an analytics queue.
+type FrameSink interface {
+	// WriteFrame is called with the frames in order. An error detaches the
+	// sink.
+	WriteFrame(f *Frame) error
+}
+
+// FrameSinkFunc is a FrameSink calling the function.
+type FrameSinkFunc func(f *Frame) error
+
+func (fn FrameSinkFunc) WriteFrame(f *Frame) error {
+	return fn(f)
+}
+
+// WithSinkOptions returns sink fed by a subscription with opts, such as
+// WithSubscriptionBuffer and WithSubscriptionPolicy. Without it, a sink gets
+// the default subscription, on which a slow sink skips to the newest frame.
+func WithSinkOptions(sink FrameSink, opts ...SubscribeOption) FrameSink {
+	return &optionsSink{FrameSink: sink, opts: opts}
+}
+
+type optionsSink struct {
+	FrameSink
+	opts []SubscribeOption
+}
+
+// Tee feeds several sinks with the frames of a stream, each from its own
+// goroutine and subscription, so that a slow sink does not hold the others
+// nor the viewers.
+type Tee struct {
+	subs []*Subscription
+	wg   sync.WaitGroup
+
+	m    sync.Mutex
+	errs []error
+}
+
+// Tee starts feeding sinks with the frames of the stream until the Tee or
+// the stream is closed.
+func (s *Stream) Tee(sinks ...FrameSink) (*Tee, error) {
+	t := &Tee{}
+	for _, sink := range sinks {
+		var opts []SubscribeOption
+		if o, ok := sink.(*optionsSink); ok {
+			sink, opts = o.FrameSink, o.opts
+		}
+		sub, err := s.Subscribe(opts...)
+		if err != nil {
+			t.Close()
+			return nil, err
+		}
+		t.subs = append(t.subs, sub)
+		t.wg.Add(1)
+		go t.feed(sub, sink)
+	}
+	return t, nil
+}
+
+func (t *Tee) feed(sub *Subscription, sink FrameSink) {
+	defer t.wg.Done()
+	for f := range sub.C {
+		if err := sink.WriteFrame(f); err != nil {
+			t.m.Lock()
+			t.errs = append(t.errs, err)
+			t.m.Unlock()
+			sub.Close()
+			return
+		}
+	}
+}
+
+// Errors returns the errors which detached sinks.
+func (t *Tee) Errors() []error {
+	t.m.Lock()
+	defer t.m.Unlock()
+	return append([]error(nil), t.errs...)
+}
+
+// Close detaches the sinks and waits for the calls to WriteFrame to return.
+func (t *Tee) Close() error {
+	for _, sub := range t.subs {
+		sub.Close()
+	}
+	t.wg.Wait()
+	return nil
+}
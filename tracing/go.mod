@@ -0,0 +1,17 @@
+module github.com/WarehouseRobotics/go-mjpeg/tracing
+
+go 1.23
+
+require (
+	github.com/WarehouseRobotics/go-mjpeg v0.0.0
+	go.opentelemetry.io/otel v1.32.0
+	go.opentelemetry.io/otel/trace v1.32.0
+)
+
+require (
+	github.com/go-logr/logr v1.4.2 // indirect
+	github.com/go-logr/stdr v1.2.2 // indirect
+	go.opentelemetry.io/otel/metric v1.32.0 // indirect
+)
+
+replace github.com/WarehouseRobotics/go-mjpeg => ../
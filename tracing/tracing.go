@@ -0,0 +1,109 @@
+// Package tracing follows the viewers of mjpeg streams and the connections
+// of reconnecting decoders with OpenTelemetry spans.
+//
+//	tracer := otel.Tracer("camera")
+//	stream := mjpeg.NewStream(mjpeg.WithSessions(tracing.Sessions(tracer)))
+//	dec, err := mjpeg.NewReconnectingDecoder(url,
+//		mjpeg.WithStatusFunc(tracing.Connections(ctx, tracer, nil)))
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"sync"
+
+	mjpeg "github.com/WarehouseRobotics/go-mjpeg"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Sessions return a mjpeg.SessionFunc which starts a span per viewer, ended
+// when the viewer is gone, with an event for the dropped frames and the
+// failed write recorded as an error. The span is a child of the trace
+// context of the request, as set by otelhttp or sent in its headers.
+func Sessions(t trace.Tracer) mjpeg.SessionFunc {
+	return func(r *http.Request, id mjpeg.WatcherID) mjpeg.Session {
+		ctx := r.Context()
+		if !trace.SpanContextFromContext(ctx).IsValid() {
+			ctx = otel.GetTextMapPropagator().Extract(ctx, propagation.HeaderCarrier(r.Header))
+		}
+		_, span := t.Start(ctx, "mjpeg.session",
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.Int64("mjpeg.watcher.id", int64(id)),
+				attribute.String("client.address", r.RemoteAddr),
+				attribute.String("url.path", r.URL.Path),
+			))
+		return session{span}
+	}
+}
+
+type session struct {
+	span trace.Span
+}
+
+func (s session) Dropped(n uint64) {
+	s.span.AddEvent("dropped", trace.WithAttributes(attribute.Int64("mjpeg.frames", int64(n))))
+}
+
+func (s session) Error(err error) {
+	s.span.RecordError(err)
+	s.span.SetStatus(codes.Error, err.Error())
+}
+
+func (s session) End(frames, bytes uint64) {
+	s.span.SetAttributes(
+		attribute.Int64("mjpeg.frames", int64(frames)),
+		attribute.Int64("mjpeg.bytes", int64(bytes)),
+	)
+	s.span.End()
+}
+
+// Connections return a callback for mjpeg.WithStatusFunc which makes a span,
+// child of ctx, per connection attempt of a reconnecting decoder, and a
+// "disconnected" event on ctx when an established stream breaks. next, if
+// not nil, is called with every status.
+func Connections(ctx context.Context, t trace.Tracer, next func(mjpeg.ConnStatus)) func(mjpeg.ConnStatus) {
+	var (
+		m    sync.Mutex
+		span trace.Span
+	)
+	return func(st mjpeg.ConnStatus) {
+		m.Lock()
+		switch st.State {
+		case mjpeg.Connecting:
+			// An attempt which reported no end is ended by the next one.
+			if span != nil {
+				span.End()
+			}
+			_, span = t.Start(ctx, "mjpeg.connect",
+				trace.WithSpanKind(trace.SpanKindClient),
+				trace.WithAttributes(attribute.Int("mjpeg.attempt", st.Attempt)))
+		case mjpeg.Connected:
+			if span != nil {
+				span.End()
+				span = nil
+			}
+		case mjpeg.Disconnected:
+			if span != nil {
+				// The attempt failed.
+				if st.Err != nil {
+					span.RecordError(st.Err)
+					span.SetStatus(codes.Error, st.Err.Error())
+				}
+				span.End()
+				span = nil
+			} else if err := st.Err; err != nil {
+				trace.SpanFromContext(ctx).AddEvent("disconnected", trace.WithAttributes(
+					attribute.String("error", err.Error())))
+			}
+		}
+		m.Unlock()
+		if next != nil {
+			next(st)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package mjpeg
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"time"
+)
+
+// FrameTransformer changes the frames published to a Stream or by a Relay,
+// e.g. to draw on them. Returning a nil Frame drops the frame. Orientation,
+// Crop, Resize, *Overlay and *Watermark are FrameTransformers, consecutive
+// ones decode and encode the frame once.
+type FrameTransformer interface {
+	Transform(f *Frame) (*Frame, error)
+}
+
+// FrameTransformerFunc is a function used as a FrameTransformer.
+type FrameTransformerFunc func(f *Frame) (*Frame, error)
+
+func (fn FrameTransformerFunc) Transform(f *Frame) (*Frame, error) {
+	return fn(f)
+}
+
+// WithTransformers runs ts in order on the frames when they are published,
+// after WithOrientation, WithCrop, WithOverlay and WithWatermark. The error
+// of a transformer is returned by Update and the frame is not published.
+func WithTransformers(ts ...FrameTransformer) StreamOption {
+	return func(s *Stream) {
+		s.transformers = append(s.transformers, ts...)
+	}
+}
+
+// WithRelayTransformers runs ts in order on the frames read by the relay,
+// before they are published. The frames failing are counted in
+// RelayStats.Errors.
+func WithRelayTransformers(ts ...FrameTransformer) RelayOption {
+	return func(o *relayOptions) {
+		o.transformers = append(o.transformers, ts...)
+	}
+}
+
+// Crop returns a FrameTransformer cropping the frames to r, like WithCrop.
+func Crop(r image.Rectangle) FrameTransformer {
+	return cropTransformer(r)
+}
+
+// Resize returns a FrameTransformer scaling the frames down to width,
+// keeping their aspect ratio. Narrower frames are unchanged.
+func Resize(width int) FrameTransformer {
+	return resizeTransformer(width)
+}
+
+// imageTransformer is a FrameTransformer of the package, working on the
+// decoded image. img must not be changed, a new image is returned instead.
+type imageTransformer interface {
+	FrameTransformer
+	transformImage(img image.Image, name string, t time.Time) image.Image
+}
+
+type cropTransformer image.Rectangle
+
+func (r cropTransformer) Transform(f *Frame) (*Frame, error) {
+	return pipeline{}.images(f, []imageTransformer{r}), nil
+}
+
+func (r cropTransformer) transformImage(img image.Image, _ string, _ time.Time) image.Image {
+	return cropped(img, image.Rectangle(r))
+}
+
+type resizeTransformer int
+
+func (w resizeTransformer) Transform(f *Frame) (*Frame, error) {
+	return pipeline{}.images(f, []imageTransformer{w}), nil
+}
+
+func (w resizeTransformer) transformImage(img image.Image, _ string, _ time.Time) image.Image {
+	b := img.Bounds()
+	if int(w) <= 0 || int(w) >= b.Dx() {
+		return img
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, int(w), max(1, b.Dy()*int(w)/b.Dx())))
+	scaleInto(dst, dst.Rect, img)
+	return dst
+}
+
+func (o Orientation) Transform(f *Frame) (*Frame, error) {
+	return pipeline{}.images(f, []imageTransformer{o}), nil
+}
+
+func (o Orientation) transformImage(img image.Image, _ string, _ time.Time) image.Image {
+	return oriented(img, o)
+}
+
+func (o *Overlay) Transform(f *Frame) (*Frame, error) {
+	return pipeline{}.images(f, []imageTransformer{o}), nil
+}
+
+func (o *Overlay) transformImage(img image.Image, name string, t time.Time) image.Image {
+	dst := rgba(img)
+	if err := o.draw(dst, OverlayData{Name: name, Time: t, Vars: o.Vars}); err != nil {
+		return img
+	}
+	return dst
+}
+
+func (w *Watermark) Transform(f *Frame) (*Frame, error) {
+	return pipeline{}.images(f, []imageTransformer{w}), nil
+}
+
+func (w *Watermark) transformImage(img image.Image, _ string, _ time.Time) image.Image {
+	dst := rgba(img)
+	w.draw(dst)
+	return dst
+}
+
+// pipeline runs transformers on the frames of s, which is nil for the
+// transformers used alone.
+type pipeline struct {
+	s  *Stream
+	ts []FrameTransformer
+}
+
+// newPipeline returns the pipeline of the frames published to s.
+func (s *Stream) newPipeline() pipeline {
+	var ts []FrameTransformer
+	if s.orientation != 0 {
+		ts = append(ts, s.orientation)
+	}
+	if !s.crop.Empty() {
+		ts = append(ts, Crop(s.crop))
+	}
+	if s.overlay != nil {
+		ts = append(ts, s.overlay)
+	}
+	if s.watermark != nil {
+		ts = append(ts, s.watermark)
+	}
+	return pipeline{s: s, ts: append(ts, s.transformers...)}
+}
+
+func (p pipeline) run(f *Frame) (*Frame, error) {
+	for i := 0; i < len(p.ts) && f != nil; {
+		var group []imageTransformer
+		for ; i < len(p.ts); i++ {
+			t, ok := p.ts[i].(imageTransformer)
+			if !ok {
+				break
+			}
+			group = append(group, t)
+		}
+		if group != nil {
+			f = p.images(f, group)
+			continue
+		}
+		var err error
+		if f, err = p.ts[i].Transform(f); err != nil {
+			return nil, err
+		}
+		i++
+	}
+	return f, nil
+}
+
+// images returns f transformed by ts, decoded and encoded once. A single
+// Orientation is done without decoding the frame when it can. f itself is
+// returned when it can not be decoded, a camera without an overlay is
+// better than no camera.
+func (p pipeline) images(f *Frame, ts []imageTransformer) *Frame {
+	if !isJPEGType(f.contentType) {
+		return f
+	}
+	if o, ok := ts[0].(Orientation); ok && len(ts) == 1 {
+		if b, err := transformJPEG(f.Data, o); err == nil {
+			return &Frame{Data: b, Header: f.Header, SourceSeq: f.SourceSeq, Time: f.Time}
+		}
+	}
+	img, err := f.Image()
+	if err != nil {
+		return f
+	}
+	var name string
+	if p.s != nil {
+		name = p.s.name
+	}
+	for _, t := range ts {
+		img = t.transformImage(img, name, f.Time)
+	}
+	var b []byte
+	if p.s != nil {
+		b, err = p.s.encodeVariant(img, 0)
+	} else {
+		var buf bytes.Buffer
+		err = DefaultCodec.Encode(&buf, img, &jpeg.Options{Quality: jpeg.DefaultQuality})
+		b = buf.Bytes()
+	}
+	if err != nil {
+		return f
+	}
+	return &Frame{Data: b, Header: f.Header, SourceSeq: f.SourceSeq, Time: f.Time}
+}
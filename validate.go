@@ -0,0 +1,92 @@
+package mjpeg
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"strconv"
+)
+
+// WithMaxFrameSize limit the size of a part to n bytes. Reading a larger part
+// fails with *FrameSizeError, the rest of the part is not buffered.
+func WithMaxFrameSize(n int) DecoderOption {
+	return func(o *decoderOptions) {
+		o.maxFrameSize = n
+	}
+}
+
+// FrameSizeError is returned when a part exceeds the limit set by WithMaxFrameSize.
+type FrameSizeError struct {
+	Limit int
+}
+
+func (e *FrameSizeError) Error() string {
+	return fmt.Sprintf("mjpeg: frame exceeds %d bytes", e.Limit)
+}
+
+func exceeds(n, limit int) bool {
+	return limit > 0 && n > limit
+}
+
+// WithFrameValidation make the decoder check every part for JPEG SOI/EOI
+// markers and for a Content-Length header matching the data, and skip parts
+// failing the check instead of returning jpeg decode errors. Skipped parts are
+// counted in DecoderStats.Corrupt.
+func WithFrameValidation() DecoderOption {
+	return func(o *decoderOptions) {
+		o.validate = true
+	}
+}
+
+// validJPEG reports whether b starts with SOI and ends with EOI, ignoring
+// padding some cameras append after the image.
+func validJPEG(b []byte) bool {
+	b = bytes.TrimRight(b, "\r\n\t \x00")
+	return len(b) >= 4 &&
+		b[0] == 0xFF && b[1] == 0xD8 &&
+		b[len(b)-2] == 0xFF && b[len(b)-1] == 0xD9
+}
+
+func validPart(p *part) bool {
+	if cl := p.header.Get("Content-Length"); cl != "" {
+		n, err := strconv.Atoi(cl)
+		if err != nil || n != len(p.data) {
+			return false
+		}
+	}
+	if !isJPEGType(p.header.Get("Content-Type")) {
+		return true
+	}
+	return validJPEG(p.data)
+}
+
+// isJPEGType reports whether a part of content type ct is expected to be
+// JPEG, it is when ct is missing.
+func isJPEGType(ct string) bool {
+	if ct == "" {
+		return true
+	}
+	mediatype, _, err := mime.ParseMediaType(ct)
+	if err != nil {
+		return true
+	}
+	return mediatype == "image/jpeg" || mediatype == "image/jpg" || mediatype == "image/pjpeg"
+}
+
+// WithUpdateValidation make Update refuse frames lacking JPEG SOI/EOI markers
+// with *InvalidFrameError, so that garbage does not reach the viewers. Frames
+// of other types given to UpdateTyped are not checked.
+func WithUpdateValidation() StreamOption {
+	return func(s *Stream) {
+		s.validate = true
+	}
+}
+
+// InvalidFrameError is returned by Update for a frame which is not JPEG.
+type InvalidFrameError struct {
+	Size int
+}
+
+func (e *InvalidFrameError) Error() string {
+	return fmt.Sprintf("mjpeg: invalid JPEG frame of %d bytes", e.Size)
+}
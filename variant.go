@@ -0,0 +1,138 @@
+package mjpeg
+
+import (
+	"image"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// WithClientWidths lets the viewers of ServeHTTP ask for narrower frames
+// with the query parameter width, e.g. ?width=640 for mobile viewers. The
+// width is rounded down to one of widths, or to the smallest. The frames
+// are decoded, resized and encoded again once per width, whatever the
+// number of viewers. Frames narrower than the width are sent unchanged.
+func WithClientWidths(widths ...int) StreamOption {
+	return func(s *Stream) {
+		s.widths = append([]int(nil), widths...)
+		sort.Ints(s.widths)
+	}
+}
+
+// WithClientQualities lets the viewers of ServeHTTP ask for the JPEG quality
+// of the frames with the query parameter quality, ?quality=low, med or
+// high. Like for WithClientWidths, the frames are encoded again once per
+// quality and shared by the viewers.
+func WithClientQualities(low, med, high int) StreamOption {
+	return func(s *Stream) {
+		s.qualities = map[string]int{"low": low, "med": med, "medium": med, "high": high}
+	}
+}
+
+// variantKey tells how a frame is transcoded for a client, the zero value
+// is the frame itself.
+type variantKey struct {
+	width   int
+	quality int
+	overlay bool
+	region  string
+}
+
+// variant is a transcoded frame, cached in Frame.variants.
+type variant struct {
+	once sync.Once
+	f    *Frame
+}
+
+// clientVariant returns the variant asked by the query of r.
+func (s *Stream) clientVariant(r *http.Request) variantKey {
+	var k variantKey
+	if len(s.widths) > 0 {
+		if w, err := strconv.Atoi(r.URL.Query().Get("width")); err == nil && w > 0 {
+			k.width = s.widths[0]
+			for _, allowed := range s.widths {
+				if allowed <= w {
+					k.width = allowed
+				}
+			}
+		}
+	}
+	if s.qualities != nil {
+		k.quality = s.qualities[r.URL.Query().Get("quality")]
+	}
+	if s.clientOverlay != nil {
+		k.overlay, _ = strconv.ParseBool(r.URL.Query().Get("overlay"))
+	}
+	if s.regions != nil {
+		k.region = s.clientRegion(r)
+	}
+	return k
+}
+
+// variant returns f transcoded for k. It is made by the first client asking
+// for it and shared by the others. f itself is returned when it can not be
+// transcoded.
+func (s *Stream) variant(f *Frame, k variantKey) *Frame {
+	if k == (variantKey{}) {
+		return f
+	}
+	v, _ := f.variants.LoadOrStore(k, &variant{})
+	vf := v.(*variant)
+	vf.once.Do(func() {
+		vf.f = s.transcode(f, k)
+	})
+	if vf.f == nil {
+		return f
+	}
+	return vf.f
+}
+
+func (s *Stream) transcode(f *Frame, k variantKey) *Frame {
+	codec := f.codec
+	if codec == nil {
+		codec = DefaultCodec
+	}
+	cfg, err := decodeImageConfig(codec, f.Data)
+	if err != nil {
+		return nil
+	}
+	width := cfg.Width
+	if k.region != "" {
+		width = s.regions[k.region].Intersect(image.Rect(0, 0, cfg.Width, cfg.Height)).Dx()
+	}
+	resize := k.width > 0 && k.width < width
+	if !resize && k.quality == 0 && !k.overlay && k.region == "" {
+		return nil
+	}
+	img, err := f.Image()
+	if err != nil {
+		return nil
+	}
+	if k.region != "" {
+		img = cropped(img, s.regions[k.region])
+	}
+	if resize {
+		b := img.Bounds()
+		dst := image.NewRGBA(image.Rect(0, 0, k.width, max(1, b.Dy()*k.width/b.Dx())))
+		scaleInto(dst, dst.Rect, img)
+		img = dst
+	}
+	if k.overlay {
+		dst, ok := img.(*image.RGBA)
+		if !ok {
+			dst = rgba(img)
+		}
+		o := s.clientOverlay
+		if err := o.draw(dst, OverlayData{Name: s.name, Time: f.Time, Vars: o.Vars}); err != nil {
+			return nil
+		}
+		img = dst
+	}
+
+	b, err := s.encodeVariant(img, k.quality)
+	if err != nil {
+		return nil
+	}
+	return &Frame{Data: b, Header: f.Header, Seq: f.Seq, Time: f.Time}
+}
@@ -0,0 +1,55 @@
+package mjpeg
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+// Watermark is an image, e.g. a PNG logo with alpha, composited on the
+// frames by WithWatermark.
+type Watermark struct {
+	Image image.Image
+	// Corner is where the image is drawn, TopLeft by default.
+	Corner Corner
+	// Margin is the distance in pixels between the image and the borders
+	// of the frame.
+	Margin int
+	// Opacity scales the alpha of the image, from 0 to 1. 0 is taken as 1.
+	Opacity float64
+}
+
+// WithWatermark composites w on every frame when it is published, so that
+// every viewer gets it. The image can be decoded from a PNG file with
+// image/png.
+func WithWatermark(w Watermark) StreamOption {
+	return func(s *Stream) {
+		s.watermark = &w
+	}
+}
+
+// draw composites w on dst.
+func (w *Watermark) draw(dst *image.RGBA) {
+	if w.Image == nil {
+		return
+	}
+	src := w.Image.Bounds()
+	r := image.Rect(0, 0, src.Dx(), src.Dy())
+	b, m := dst.Bounds(), w.Margin
+	switch w.Corner {
+	case TopRight:
+		r = r.Add(image.Pt(b.Max.X-r.Dx()-m, b.Min.Y+m))
+	case BottomLeft:
+		r = r.Add(image.Pt(b.Min.X+m, b.Max.Y-r.Dy()-m))
+	case BottomRight:
+		r = r.Add(image.Pt(b.Max.X-r.Dx()-m, b.Max.Y-r.Dy()-m))
+	default:
+		r = r.Add(image.Pt(b.Min.X+m, b.Min.Y+m))
+	}
+	if w.Opacity <= 0 || w.Opacity >= 1 {
+		draw.Draw(dst, r, w.Image, src.Min, draw.Over)
+		return
+	}
+	mask := image.NewUniform(color.Alpha{A: uint8(w.Opacity * 0xFF)})
+	draw.DrawMask(dst, r, w.Image, src.Min, mask, image.Point{}, draw.Over)
+}
@@ -0,0 +1,143 @@
+package mjpeg
+
+import (
+	"context"
+	"time"
+
+	"github.com/WarehouseRobotics/go-mjpeg/internal/timescale"
+)
+
+// rtpVideoClock is the clock rate of the RTP timestamps of video, 90 kHz.
+const rtpVideoClock = 90000
+
+// Sample is a frame timed for a WebRTC track. The fields are those of the
+// media.Sample of pion, so that a SampleSource feeds a TrackLocalStaticSample:
+//
+//	s, err := src.ReadSample(ctx)
+//	...
+//	track.WriteSample(media.Sample{Data: s.Data, Timestamp: s.Timestamp, Duration: s.Duration})
+type Sample struct {
+	Data []byte
+	// Timestamp is the time of the frame.
+	Timestamp time.Time
+	// Duration is the time since the previous sample, by which the track
+	// advances its RTP timestamp. The next frame is not known yet, so it
+	// is the best guess of a live stream.
+	Duration time.Duration
+	// RTPTimestamp is the time of the sample on the 90 kHz clock of RTP
+	// video, from the first sample, for the tracks which take RTP packets.
+	RTPTimestamp uint32
+	// Frame is the frame of the sample.
+	Frame *Frame
+}
+
+// SampleOption is an option of NewSampleSource
+type SampleOption func(*sampleOptions)
+
+type sampleOptions struct {
+	encode   func(ctx context.Context, f *Frame) ([]byte, error)
+	duration time.Duration
+}
+
+// WithSampleEncoder sets encode to turn the frames into the data of the
+// samples. Browsers do not decode JPEG over WebRTC, encode is the VP8 or
+// H.264 encoder of the track. A nil result skips the frame, e.g. while the
+// encoder buffers. Without it, the data is the JPEG of the frame.
+func WithSampleEncoder(encode func(ctx context.Context, f *Frame) ([]byte, error)) SampleOption {
+	return func(o *sampleOptions) {
+		o.encode = encode
+	}
+}
+
+// WithFirstSampleDuration sets the Duration of the first sample, which has no
+// previous one, 100ms by default.
+func WithFirstSampleDuration(d time.Duration) SampleOption {
+	return func(o *sampleOptions) {
+		o.duration = d
+	}
+}
+
+// SampleSource reads the frames of a Stream as WebRTC samples. It keeps a
+// single frame, a slow track gets the newest one so that the latency stays
+// that of the network.
+type SampleSource struct {
+	sub  *Subscription
+	opts sampleOptions
+
+	start, last time.Time
+}
+
+// NewSampleSource returns a SampleSource of the frames of s, starting with
+// the current one.
+func NewSampleSource(s *Stream, opts ...SampleOption) (*SampleSource, error) {
+	o := sampleOptions{duration: 100 * time.Millisecond}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	sub, err := s.Subscribe(WithSubscriptionBuffer(1), WithSubscriptionPolicy(DropOldest))
+	if err != nil {
+		return nil, err
+	}
+	return &SampleSource{sub: sub, opts: o}, nil
+}
+
+// ReadSample waits for the next frame and returns its sample. It returns
+// ErrStreamClosed once the stream or the source is closed. Without an
+// encoder, the frames which are not JPEG are skipped.
+func (src *SampleSource) ReadSample(ctx context.Context) (Sample, error) {
+	for {
+		var f *Frame
+		select {
+		case f = <-src.sub.C:
+			if f == nil {
+				return Sample{}, ErrStreamClosed
+			}
+		case <-ctx.Done():
+			return Sample{}, ctx.Err()
+		}
+		data := f.Data
+		if src.opts.encode != nil {
+			var err error
+			if data, err = src.opts.encode(ctx, f); err != nil {
+				return Sample{}, err
+			}
+		} else if !isJPEGType(f.contentType) {
+			continue
+		}
+		if data == nil {
+			continue
+		}
+		return src.sample(f, data), nil
+	}
+}
+
+// sample times data, of frame f.
+func (src *SampleSource) sample(f *Frame, data []byte) Sample {
+	t := f.Time
+	if t.IsZero() {
+		t = time.Now()
+	}
+	d := src.opts.duration
+	if src.start.IsZero() {
+		src.start = t
+	} else {
+		// The heartbeat repeats the time of a frame.
+		if !t.After(src.last) {
+			t = src.last.Add(time.Millisecond)
+		}
+		d = t.Sub(src.last)
+	}
+	src.last = t
+	return Sample{
+		Data:         data,
+		Timestamp:    t,
+		Duration:     d,
+		RTPTimestamp: uint32(timescale.Ticks(t.Sub(src.start), rtpVideoClock)),
+		Frame:        f,
+	}
+}
+
+// Close detaches the source from the stream.
+func (src *SampleSource) Close() error {
+	return src.sub.Close()
+}
@@ -0,0 +1,234 @@
+package mjpeg
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// websocketGUID is appended to the key of the handshake, RFC 6455 1.3.
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// WebSocket opcodes.
+const (
+	wsBinary = 0x2
+	wsClose  = 0x8
+	wsPing   = 0x9
+	wsPong   = 0xA
+)
+
+// maxWebSocketMessage is the size of the largest message read from a viewer,
+// which has nothing to send yet.
+const maxWebSocketMessage = 4 << 10
+
+// ServeWebSocket sends the frames to the viewer of r as binary WebSocket
+// messages, one JPEG per message, with the policies of ServeHTTP. Browsers
+// draw them on a canvas with less latency than multipart, and WebSockets
+// get through the proxies which buffer multipart responses. Only HTTP/1.1
+// upgrades are supported. With WithCORS, the Origin must be allowed.
+func (s *Stream) ServeWebSocket(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet || !headerHasToken(r.Header, "Connection", "upgrade") || !headerHasToken(r.Header, "Upgrade", "websocket") {
+		http.Error(w, "websocket upgrade required", http.StatusBadRequest)
+		return
+	}
+	if r.ProtoMajor != 1 {
+		http.Error(w, "websocket needs HTTP/1.1", http.StatusHTTPVersionNotSupported)
+		return
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "unsupported websocket version", http.StatusUpgradeRequired)
+		return
+	}
+	if key, err := base64.StdEncoding.DecodeString(r.Header.Get("Sec-WebSocket-Key")); err != nil || len(key) != 16 {
+		http.Error(w, "invalid websocket key", http.StatusBadRequest)
+		return
+	}
+	// Browsers send the cookies of the site with cross-origin WebSockets.
+	if origin := r.Header.Get("Origin"); len(s.origins) > 0 && origin != "" && s.allowedOrigin(origin) == "" {
+		http.Error(w, "origin not allowed", http.StatusForbidden)
+		return
+	}
+	s.serve(w, r, s.openWebSocket)
+}
+
+// openWebSocket takes over the connection of r and answers the handshake.
+func (s *Stream) openWebSocket(w http.ResponseWriter, r *http.Request, c *client) (viewerConn, context.Context, error) {
+	conn, brw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		http.Error(w, "websocket not supported", http.StatusInternalServerError)
+		return nil, nil, err
+	}
+	h := sha1.Sum([]byte(r.Header.Get("Sec-WebSocket-Key") + websocketGUID))
+	resp := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(h[:]) + "\r\n\r\n"
+	if _, err := io.WriteString(conn, resp); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	// The request context is not canceled once the connection is hijacked,
+	// the reader cancels ctx when the viewer goes.
+	ctx, cancel := context.WithCancel(context.WithoutCancel(r.Context()))
+	ws := &wsConn{conn: conn, cancel: cancel}
+	go ws.read(brw.Reader)
+	return ws, ctx, nil
+}
+
+// wsConn is the WebSocket connection of ServeWebSocket.
+type wsConn struct {
+	conn   net.Conn
+	cancel context.CancelFunc
+	// m orders the messages of the frames and the answers of the reader.
+	m      sync.Mutex
+	header [10]byte
+	closed bool
+}
+
+func (ws *wsConn) writePart(f *Frame, _ time.Time) (int, error) {
+	if err := ws.write(wsBinary, f.Data); err != nil {
+		return 0, err
+	}
+	return len(f.Data), nil
+}
+
+// write sends a message in a single write.
+func (ws *wsConn) write(op byte, p []byte) error {
+	ws.m.Lock()
+	defer ws.m.Unlock()
+	if ws.closed {
+		return net.ErrClosed
+	}
+	h := ws.header[:2]
+	h[0] = 0x80 | op
+	switch n := len(p); {
+	case n < 126:
+		h[1] = byte(n)
+	case n <= 0xFFFF:
+		h[1] = 126
+		h = binary.BigEndian.AppendUint16(h, uint16(n))
+	default:
+		h[1] = 127
+		h = binary.BigEndian.AppendUint64(h, uint64(n))
+	}
+	bufs := net.Buffers{h, p}
+	_, err := bufs.WriteTo(ws.conn)
+	if op == wsClose {
+		ws.closed = true
+	}
+	return err
+}
+
+// close sends the closing handshake, the viewer is to close the connection.
+func (ws *wsConn) close() error {
+	return ws.write(wsClose, binary.BigEndian.AppendUint16(nil, 1001))
+}
+
+func (ws *wsConn) flush() error {
+	return nil
+}
+
+func (ws *wsConn) setWriteDeadline(t time.Time) error {
+	return ws.conn.SetWriteDeadline(t)
+}
+
+func (ws *wsConn) release() {
+	ws.cancel()
+	ws.m.Lock()
+	closed := ws.closed
+	ws.m.Unlock()
+	if !closed {
+		ws.conn.Close()
+		return
+	}
+	// The viewer has a moment to answer the closing handshake and close its
+	// end first, so that the server is not left in the TIME_WAIT state.
+	ws.conn.SetReadDeadline(time.Now().Add(time.Second))
+}
+
+// read answers the control messages of the viewer and discards the others,
+// until the connection is closed.
+func (ws *wsConn) read(br *bufio.Reader) {
+	defer ws.conn.Close()
+	defer ws.cancel()
+	for {
+		op, p, err := readWebSocketMessage(br)
+		if err != nil {
+			if errors.Is(err, errWebSocketTooLarge) {
+				ws.write(wsClose, binary.BigEndian.AppendUint16(nil, 1009))
+			}
+			return
+		}
+		switch op {
+		case wsPing:
+			ws.write(wsPong, p)
+		case wsClose:
+			ws.write(wsClose, p[:min(len(p), 2)])
+			return
+		}
+	}
+}
+
+var errWebSocketTooLarge = errors.New("mjpeg: websocket message too large")
+
+// readWebSocketMessage reads a frame of the viewer, which is masked.
+func readWebSocketMessage(br *bufio.Reader) (byte, []byte, error) {
+	var h [14]byte
+	if _, err := io.ReadFull(br, h[:2]); err != nil {
+		return 0, nil, err
+	}
+	op, n := h[0]&0x0F, uint64(h[1]&0x7F)
+	if h[1]&0x80 == 0 {
+		return 0, nil, errors.New("mjpeg: unmasked websocket message")
+	}
+	switch n {
+	case 126:
+		if _, err := io.ReadFull(br, h[2:4]); err != nil {
+			return 0, nil, err
+		}
+		n = uint64(binary.BigEndian.Uint16(h[2:4]))
+	case 127:
+		if _, err := io.ReadFull(br, h[2:10]); err != nil {
+			return 0, nil, err
+		}
+		n = binary.BigEndian.Uint64(h[2:10])
+	}
+	if n > maxWebSocketMessage {
+		return 0, nil, errWebSocketTooLarge
+	}
+	var mask [4]byte
+	if _, err := io.ReadFull(br, mask[:]); err != nil {
+		return 0, nil, err
+	}
+	p := make([]byte, n)
+	if _, err := io.ReadFull(br, p); err != nil {
+		return 0, nil, err
+	}
+	for i := range p {
+		p[i] ^= mask[i%4]
+	}
+	return op, p, nil
+}
+
+// headerHasToken reports whether the comma separated values of the header
+// key have token, ignoring case.
+func headerHasToken(h http.Header, key, token string) bool {
+	for _, v := range h.Values(key) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
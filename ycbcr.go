@@ -0,0 +1,124 @@
+package mjpeg
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"io"
+)
+
+// ErrNotYCbCr is returned by DecodeYCbCr when the frame is neither YCbCr nor grayscale, e.g. CMYK.
+var ErrNotYCbCr = errors.New("mjpeg: frame is not YCbCr")
+
+// DecodeYCbCr decode the next frame to *image.YCbCr as produced by the JPEG
+// decoder, without conversion to RGBA.
+//
+// The Y plane holds one sample per pixel, row r starting at Y[r*YStride].
+// The Cb and Cr planes are subsampled according to SubsampleRatio, most
+// cameras use 4:2:0 where one chroma sample covers 2x2 pixels, and 4:2:2 where
+// it covers 2x1 pixels. Use YOffset and COffset to locate the samples of a pixel.
+// Pipelines which need luma only can use the Y plane and ignore the rest.
+//
+// Grayscale frames are returned with the gray samples as the Y plane and
+// neutral 4:2:0 chroma planes.
+func (d *Decoder) DecodeYCbCr() (*image.YCbCr, error) {
+	img, err := d.DecodeContext(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	return toYCbCr(img)
+}
+
+func toYCbCr(img image.Image) (*image.YCbCr, error) {
+	switch m := img.(type) {
+	case *image.YCbCr:
+		return m, nil
+	case *image.Gray:
+		y := image.NewYCbCr(m.Rect, image.YCbCrSubsampleRatio420)
+		for r := 0; r < m.Rect.Dy(); r++ {
+			copy(y.Y[r*y.YStride:r*y.YStride+m.Rect.Dx()], m.Pix[r*m.Stride:])
+		}
+		for i := range y.Cb {
+			y.Cb[i] = 128
+			y.Cr[i] = 128
+		}
+		return y, nil
+	}
+	return nil, ErrNotYCbCr
+}
+
+// YCbCrDecoderInto is implemented by a Codec which can decode straight to the
+// buffers of an existing image.
+type YCbCrDecoderInto interface {
+	DecodeYCbCrInto(r io.Reader, dst *image.YCbCr) error
+}
+
+// DecodeInto decode the next frame into dst, re-using the planes of dst when
+// the dimensions and subsample ratio match, and replacing them otherwise. With
+// a Codec implementing YCbCrDecoderInto no image is allocated per frame. With
+// image/jpeg the decoded image is a short lived temporary copied into dst.
+func (d *Decoder) DecodeInto(dst *image.YCbCr) error {
+	p, err := d.nextPart(context.Background())
+	if err != nil {
+		return err
+	}
+	defer p.release()
+
+	if c, ok := d.opts.jpegCodec().(YCbCrDecoderInto); ok {
+		if err := c.DecodeYCbCrInto(bytes.NewReader(p.data), dst); err != nil {
+			d.stats.errors.Add(1)
+			return err
+		}
+		return nil
+	}
+
+	img, err := d.opts.jpegCodec().Decode(bytes.NewReader(p.data))
+	if err != nil {
+		d.stats.errors.Add(1)
+		return err
+	}
+	src, err := toYCbCr(img)
+	if err != nil {
+		return err
+	}
+	copyYCbCr(dst, src)
+	return nil
+}
+
+// copyYCbCr copies src into dst, re-allocating dst only when its layout differs.
+func copyYCbCr(dst, src *image.YCbCr) {
+	w, h := src.Rect.Dx(), src.Rect.Dy()
+	cw, ch := chromaSize(src)
+	if dst.Rect != src.Rect || dst.SubsampleRatio != src.SubsampleRatio ||
+		dst.YStride < w || len(dst.Y) < (h-1)*dst.YStride+w ||
+		dst.CStride < cw || len(dst.Cb) < (ch-1)*dst.CStride+cw || len(dst.Cr) < (ch-1)*dst.CStride+cw {
+		*dst = *image.NewYCbCr(src.Rect, src.SubsampleRatio)
+	}
+	for r := 0; r < h; r++ {
+		copy(dst.Y[r*dst.YStride:r*dst.YStride+w], src.Y[r*src.YStride:])
+	}
+	for r := 0; r < ch; r++ {
+		copy(dst.Cb[r*dst.CStride:r*dst.CStride+cw], src.Cb[r*src.CStride:])
+		copy(dst.Cr[r*dst.CStride:r*dst.CStride+cw], src.Cr[r*src.CStride:])
+	}
+}
+
+// chromaSize returns the width and height of the chroma planes of m.
+func chromaSize(m *image.YCbCr) (int, int) {
+	r := m.Rect
+	w, h := r.Dx(), r.Dy()
+	switch m.SubsampleRatio {
+	case image.YCbCrSubsampleRatio422:
+		return (r.Max.X+1)/2 - r.Min.X/2, h
+	case image.YCbCrSubsampleRatio420:
+		return (r.Max.X+1)/2 - r.Min.X/2, (r.Max.Y+1)/2 - r.Min.Y/2
+	case image.YCbCrSubsampleRatio440:
+		return w, (r.Max.Y+1)/2 - r.Min.Y/2
+	case image.YCbCrSubsampleRatio411:
+		return (r.Max.X+3)/4 - r.Min.X/4, h
+	case image.YCbCrSubsampleRatio410:
+		return (r.Max.X+3)/4 - r.Min.X/4, (r.Max.Y+1)/2 - r.Min.Y/2
+	}
+	return w, h
+}